@@ -1,19 +1,135 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/codemicro/cligen/internal/diff"
 	"github.com/codemicro/cligen/internal/gen"
 	"github.com/codemicro/cligen/internal/parse"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 )
 
+const defaultOutputFilename = "runner.cligen.go"
+
+type options struct {
+	dir         string
+	output      string
+	packageName string
+	check       bool
+}
+
 func main() {
-	program, err := parse.Directory("testdata/package")
-	fmt.Printf("%#v %v\n", program, err)
+	opts := new(options)
+
+	flag.StringVar(&opts.dir, "dir", ".", "directory of the package to generate a runner for")
+	flag.StringVar(&opts.output, "out", "", "path of the generated file (default \"<dir>/"+defaultOutputFilename+"\")")
+	flag.StringVar(&opts.packageName, "package", "", "package name to use in the generated file (default the name of the input package)")
+	flag.BoolVar(&opts.check, "check", false, "check that the output file is up to date instead of writing it")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n\nFlags:\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	applyGoGenerateEnvironment(opts, setFlags)
+
+	if err := run(opts); err != nil {
+		var diags parse.Diagnostics
+		if errors.As(err, &diags) {
+			// each diagnostic already includes its position, so print them like compiler errors
+			for _, d := range diags {
+				fmt.Fprintln(os.Stderr, d.Error())
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "cligen: %s\n", err.Error())
+		}
+		os.Exit(1)
+	}
+}
+
+// applyGoGenerateEnvironment fills in any options not explicitly set on the
+// command line from the environment variables provided by `go generate`.
+func applyGoGenerateEnvironment(opts *options, setFlags map[string]bool) {
+	goPackage := os.Getenv("GOPACKAGE")
+	if goPackage == "" {
+		// not being run by go generate
+		return
+	}
+
+	if !setFlags["dir"] {
+		// go generate runs commands in the directory containing GOFILE, and GOFILE is the base name of that file
+		opts.dir = filepath.Dir(os.Getenv("GOFILE"))
+	}
+
+	// GOPACKAGE names the package containing GOFILE, which isn't necessarily the package being generated for
+	if !setFlags["package"] && !setFlags["dir"] {
+		opts.packageName = goPackage
+	}
+}
+
+func run(opts *options) error {
+	if opts.dir == "" {
+		return errors.New("input directory cannot be empty")
+	}
+
+	if opts.output == "" {
+		opts.output = filepath.Join(opts.dir, defaultOutputFilename)
+	}
+
+	program, err := parse.Directory(opts.dir)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", opts.dir, err)
+	}
+
+	if opts.packageName != "" {
+		program.PackageName = opts.packageName
+	}
 
 	b, err := gen.File(program)
-	fmt.Println(err)
-	fmt.Println(string(b), err)
+	if err != nil {
+		return fmt.Errorf("generate runner: %w", err)
+	}
+
+	if opts.check {
+		return checkOutput(opts.output, b)
+	}
+
+	if err := ioutil.WriteFile(opts.output, b, 0644); err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+
+	return nil
+}
+
+// checkOutput compares the contents of the existing output file to generated and returns an error if they differ,
+// after printing a diff between the two.
+func checkOutput(filename string, generated []byte) error {
+	existing, err := ioutil.ReadFile(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read existing output: %w", err)
+	}
+
+	d := diff.Unified(filename, filename+" (generated)", existing, generated)
+	if d == "" {
+		return nil
+	}
+
+	fmt.Print(d)
 
-	_ = ioutil.WriteFile("testdata/package/runner.cligen.go", b, 0644)
+	if existing == nil {
+		return fmt.Errorf("%s does not exist, run cligen to generate it", filename)
+	}
+	return fmt.Errorf("%s is out of date, run cligen to regenerate it", filename)
 }
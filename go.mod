@@ -1,5 +1,13 @@
 module github.com/codemicro/cligen
 
-go 1.16
+go 1.22.0
 
-require github.com/magefile/mage v1.11.0
+require (
+	github.com/magefile/mage v1.11.0
+	golang.org/x/tools v0.30.0
+)
+
+require (
+	golang.org/x/mod v0.23.0 // indirect
+	golang.org/x/sync v0.11.0 // indirect
+)
@@ -0,0 +1,201 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const contextLines = 3
+
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+type op struct {
+	kind opKind
+	// aIndex and bIndex are the line indexes in each input this operation applies to
+	aIndex, bIndex int
+}
+
+// Unified returns a unified diff of a and b, using aName and bName as the file names in the diff header. If a and b
+// are identical, an empty string is returned.
+func Unified(aName, bName string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+
+	aLines := splitLines(a)
+	bLines := splitLines(b)
+	ops := editScript(aLines, bLines)
+
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "--- %s\n+++ %s\n", aName, bName)
+
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == opEqual {
+			start += 1
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// extend the hunk until there's a run of unchanged lines long enough to separate it from the next one
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != opEqual {
+				end += 1
+				continue
+			}
+			runEnd := end
+			for runEnd < len(ops) && ops[runEnd].kind == opEqual {
+				runEnd += 1
+			}
+			if runEnd == len(ops) || runEnd-end > contextLines*2 {
+				break
+			}
+			end = runEnd
+		}
+
+		hunkStart := start - contextLines
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := end + contextLines
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+
+		writeHunk(sb, ops[hunkStart:hunkEnd], aLines, bLines)
+		start = hunkEnd
+	}
+
+	return sb.String()
+}
+
+func writeHunk(sb *strings.Builder, ops []op, aLines, bLines []string) {
+	var aStart, bStart, aCount, bCount int
+	aStart, bStart = -1, -1
+	for _, o := range ops {
+		if o.kind != opInsert {
+			if aStart == -1 {
+				aStart = o.aIndex
+			}
+			aCount += 1
+		}
+		if o.kind != opDelete {
+			if bStart == -1 {
+				bStart = o.bIndex
+			}
+			bCount += 1
+		}
+	}
+
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(aStart, aCount, ops[0].aIndex), hunkRange(bStart, bCount, ops[0].bIndex))
+
+	for _, o := range ops {
+		switch o.kind {
+		case opEqual:
+			writeLine(sb, " ", aLines[o.aIndex])
+		case opDelete:
+			writeLine(sb, "-", aLines[o.aIndex])
+		case opInsert:
+			writeLine(sb, "+", bLines[o.bIndex])
+		}
+	}
+}
+
+// writeLine writes line, which includes its line ending if it has one, with the given prefix. A line without an ending
+// is followed by a marker, since otherwise the diff wouldn't show what changed.
+func writeLine(sb *strings.Builder, prefix, line string) {
+	sb.WriteString(prefix + strings.TrimSuffix(line, "\n") + "\n")
+	if !strings.HasSuffix(line, "\n") {
+		sb.WriteString("\\ No newline at end of file\n")
+	}
+}
+
+func hunkRange(start, count, fallback int) string {
+	if count == 0 {
+		// an empty range is given as the line before it, which is the same as the 0-based index of the line after it
+		return fmt.Sprintf("%d,0", fallback)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits b into lines, keeping the line endings so that a final line with no ending is different to the
+// same line with one.
+func splitLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// editScript computes the shortest sequence of operations that transforms a into b using the longest common
+// subsequence of lines. Lines common to the start and end of both are matched first, so that the table is only as large
+// as the section that changed.
+func editScript(a, b []string) []op {
+	var prefix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix += 1
+	}
+	var suffix int
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix += 1
+	}
+	endA, endB := len(a)-suffix, len(b)-suffix
+
+	// lcs[i][j] is the length of the longest common subsequence of a[prefix+i:endA] and b[prefix+j:endB]
+	lcs := make([][]int, endA-prefix+1)
+	for i := range lcs {
+		lcs[i] = make([]int, endB-prefix+1)
+	}
+	for i := endA - prefix - 1; i >= 0; i -= 1 {
+		for j := endB - prefix - 1; j >= 0; j -= 1 {
+			if a[prefix+i] == b[prefix+j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []op
+	for i := 0; i < prefix; i += 1 {
+		ops = append(ops, op{kind: opEqual, aIndex: i, bIndex: i})
+	}
+	i, j := prefix, prefix
+	for i < endA || j < endB {
+		switch {
+		case i < endA && j < endB && a[i] == b[j]:
+			ops = append(ops, op{kind: opEqual, aIndex: i, bIndex: j})
+			i += 1
+			j += 1
+		case i < endA && (j == endB || lcs[i-prefix+1][j-prefix] >= lcs[i-prefix][j-prefix+1]):
+			ops = append(ops, op{kind: opDelete, aIndex: i, bIndex: j})
+			i += 1
+		default:
+			ops = append(ops, op{kind: opInsert, aIndex: i, bIndex: j})
+			j += 1
+		}
+	}
+	for ; i < len(a); i += 1 {
+		ops = append(ops, op{kind: opEqual, aIndex: i, bIndex: j})
+		j += 1
+	}
+	return ops
+}
@@ -6,63 +6,38 @@ import (
 	"fmt"
 	"github.com/codemicro/cligen/internal/parse"
 	"go/format"
-	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
-	"unicode"
+	"time"
 )
 
-var (
-	usedIds  = make(map[string]struct{})
-	replacer = strings.NewReplacer(
-		"0", "a",
-		"1", "b",
-		"2", "c",
-		"3", "d",
-		"4", "e",
-		"5", "f",
-		"6", "g",
-		"7", "h",
-		"8", "i",
-		"9", "j",
-		"A", "k",
-		"B", "l",
-		"C", "m",
-		"D", "n",
-		"E", "o",
-		"F", "p",
-	)
-	rng = rand.New(rand.NewSource(45986749679038456)) // so the same IDs always appear in the same sequence
-)
-
-func nextIdentifier(exported ...bool) string {
-	alreadyInUse := true
-	var x string
-	for alreadyInUse {
-
-		x = replacer.Replace(strconv.FormatInt(rng.Int63n(100000), 16)) // ensure string has no digits in it
-
-		transformFunc := unicode.ToLower
-		if len(exported) > 0 && exported[0] {
-			transformFunc = unicode.ToUpper
-		}
-
-		x = string(transformFunc(rune(x[0]))) + x[1:]
-		_, alreadyInUse = usedIds[x]
-	}
-	return x
-}
-
 type generator struct {
-	b *bytes.Buffer
+	b       *bytes.Buffer
+	ids     *identifiers
+	imports map[string]struct{}
+	// hasConfig is set if flag values can also be read from a config file.
+	hasConfig bool
+	// patterns are the regular expressions used by the generated code, in the order they were first used, and
+	// patternIDs maps each one to the package level variable it is compiled into.
+	patterns   []string
+	patternIDs map[string]string
 }
 
-func newGenerator() *generator {
+func newGenerator(ids *identifiers) *generator {
 	return &generator{
-		b: new(bytes.Buffer),
+		b:          new(bytes.Buffer),
+		ids:        ids,
+		imports:    make(map[string]struct{}),
+		patternIDs: make(map[string]string),
 	}
 }
 
+// addImport marks the package with the given import path as used by the generated code.
+func (g *generator) addImport(importPath string) {
+	g.imports[importPath] = struct{}{}
+}
+
 func (g *generator) w(x string, args ...interface{}) {
 	g.b.WriteString(fmt.Sprintf(x, args...) + "\n")
 }
@@ -82,48 +57,103 @@ func (g *generator) checkRuntimeError(x string) {
 }
 
 func (g *generator) returnRuntimeError(x string, args ...interface{}) {
-	g.b.WriteString("return &runtimeError{original: errors.New(\"" + fmt.Sprintf(x, args...) + "\")}")
+	g.b.WriteString("return &runtimeError{original: errors.New(" + strconv.Quote(fmt.Sprintf(x, args...)) + ")}")
 }
 
 func (g *generator) returnPreparationError(cmdName, x string, args ...interface{}) {
-	g.b.WriteString("return &preparationError{original: errors.New(\"" + fmt.Sprintf(x, args...) + "\"), text: " + fmt.Sprintf("%#v", cmdName) + "}")
+	g.b.WriteString("return &preparationError{original: errors.New(" + strconv.Quote(fmt.Sprintf(x, args...)) + "), text: " + strconv.Quote(cmdName) + "}")
 }
 
 func File(program *parse.Program) ([]byte, error) {
 
-	helpTexts := makeHelpTexts(program.Functions)
+	var hasFlags bool
+	for _, finfo := range program.Functions {
+		for _, arg := range finfo.Signature.Argument {
+			if arg.IsFlag() {
+				hasFlags = true
+			}
+		}
+	}
 
-	g := newGenerator()
+	// a config file can only set flags, so there's no point accepting one if no command takes any
+	hasConfig := program.Config && hasFlags
 
-	g.w("// Code generated by cligen. DO NOT EDIT.")
-	g.w("// See https://github.com/codemicro/cligen")
+	helpTexts := makeHelpTexts(program.Functions, hasConfig)
 
-	g.w("")
+	// names referenced from inside run
+	var usedNames []string
+	for _, finfo := range program.Functions {
+		usedNames = append(usedNames, finfo.Name)
+		for _, arg := range finfo.Signature.Argument {
+			if arg.TypePackage != "" {
+				// the package name that qualifies the type
+				usedNames = append(usedNames, strings.SplitN(arg.Type, ".", 2)[0])
+			}
+		}
+	}
 
-	g.w("package %s", program.PackageName)
+	g := newGenerator(newIdentifiers(usedNames...))
+	g.hasConfig = hasConfig
 
-	imports := []string{"errors", "strings", "github.com/codemicro/cligen/parsecli", "math/bits", "strconv", "fmt", "os"}
-	g.w("import (")
-	for _, i := range imports {
-		g.w(`"%s"`, i)
+	for _, x := range []string{"errors", "strings", "github.com/codemicro/cligen/parsecli", "math/bits", "fmt", "os"} {
+		g.addImport(x)
 	}
-	g.w(")")
 
 	g.w("var intSize = bits.UintSize")
 	g.w("var funcNames = map[string]string{")
 	g.w(`"help": "help",`)
 	for _, finfo := range program.Functions {
 		g.w(`%#v: %#v,`, strings.ToLower(finfo.UIName), finfo.UIName)
+		path := finfo.Path()
+		for _, alias := range finfo.Aliases {
+			g.w(`%#v: %#v,`, strings.ToLower(strings.Join(append(path[:len(path)-1:len(path)-1], alias), " ")), finfo.UIName)
+		}
+	}
+	for _, group := range commandGroups(program.Functions) {
+		g.w(`%#v: %#v,`, strings.ToLower(group), group)
+	}
+	g.w("}")
+
+	g.w("var cligenCommandGroups = map[string]bool{")
+	for _, group := range commandGroups(program.Functions) {
+		g.w(`%#v: true,`, group)
 	}
 	g.w("}")
 
 	g.w("var funcHelps = map[string]string{")
 	g.w(`"": fmt.Sprintf(%#v, execName),`, helpTexts[""])
-	for fname, finfo := range program.Functions {
-		g.w("%#v: fmt.Sprintf(%#v, execName),", finfo.UIName, helpTexts[fname])
+	for _, finfo := range program.Functions {
+		g.w("%#v: fmt.Sprintf(%#v, execName),", finfo.UIName, helpTexts[finfo.UIName])
+	}
+	for _, group := range commandGroups(program.Functions) {
+		g.w("%#v: fmt.Sprintf(%#v, execName),", group, helpTexts[group])
+	}
+	g.w("}")
+
+	g.w("var cligenValueFlags = map[string]map[string]bool{")
+	for _, finfo := range program.Functions {
+		var names []string
+		if hasConfig {
+			names = append(names, `"config": true,`)
+		}
+		for _, arg := range finfo.Signature.Argument {
+			if takesValue(arg) {
+				names = append(names, fmt.Sprintf("%#v: true,", flagName(arg)))
+				if arg.Short != "" {
+					names = append(names, fmt.Sprintf("%#v: true,", strings.ToLower(arg.Short)))
+				}
+			}
+		}
+		if len(names) != 0 {
+			g.w("%#v: {%s},", finfo.UIName, strings.Join(names, " "))
+		}
 	}
 	g.w("}")
 
+	if hasConfig {
+		writeConfigLoader(g, program)
+	}
+
 	g.w("var execName = os.Args[0]")
 
 	g.w("type runtimeError struct { original error; text string }")
@@ -132,6 +162,18 @@ func File(program *parse.Program) ([]byte, error) {
 	g.w("type preparationError struct { original error; text string }")
 	g.w("func (err *preparationError) Error() string { return err.original.Error() }")
 
+	for _, finfo := range program.Functions {
+		if hasFlagGroups(finfo) {
+			g.w("func cligenFlagGiven(flags map[string][]string, names ...string) bool {")
+			g.w("for _, name := range names {")
+			g.w("if _, ok := flags[name]; ok { return true }")
+			g.w("}")
+			g.w("return false")
+			g.w("}")
+			break
+		}
+	}
+
 	g.w("func Start(input []string) error {")
 	{
 		g.w("err := run(input)")
@@ -158,41 +200,93 @@ func File(program *parse.Program) ([]byte, error) {
 	}
 	g.w("}")
 
+	// cligenFindCommand returns the name of the command or group of commands at the start of input, and whatever is
+	// left after it
+	g.w("func cligenFindCommand(input []string) (string, []string) {")
+	{
+		g.w("var name string")
+		g.w("for len(input) != 0 {")
+		g.w(`fname, ok := funcNames[strings.ToLower(strings.TrimSpace(name + " " + input[0]))]`)
+		g.w("if !ok { break }")
+		g.w("name, input = fname, input[1:]")
+		g.w("if !cligenCommandGroups[name] { break }")
+		g.w("}")
+		g.w("return name, input")
+	}
+	g.w("}")
+
 	g.w("func run(input []string) error {")
 
+	if hasConfig {
+		// --config is a global flag, so can also be given before the command
+		g.w("var configPath string")
+		g.w(`for len(input) != 0 && strings.HasPrefix(input[0], "-") {`)
+		{
+			g.w(`split := strings.SplitN(strings.TrimLeft(input[0], "-"), "=", 2)`)
+			g.w(`if !strings.EqualFold(split[0], "config") { break }`)
+			g.w("if len(split) == 1 {")
+			g.w("if len(input) < 2 {")
+			g.returnPreparationError("", "flag --config requires a value")
+			g.w("}")
+			g.w("split, input = append(split, input[1]), input[1:]")
+			g.w("}")
+			g.w("configPath, input = split[1], input[1:]")
+		}
+		g.w("}")
+	}
+
 	g.w("if len(input) == 0 {")
 	g.returnPreparationError("", "not enough arguments")
 	g.w("}")
 
-	g.w("var runFunc string")
+	g.w("runFunc, input := cligenFindCommand(input)")
 
-	g.w(`if fname, ok := funcNames[strings.ToLower(input[0])]; !ok {`)
+	g.w(`if runFunc == "" {`)
 	g.returnPreparationError("", "no matching targets found")
-	g.w("} else {")
-	g.w("runFunc = fname")
 	g.w("}")
 
-	g.w("parsedFlags, parsedArgs, err := parsecli.Slice(input[1:])")
+	g.w("if cligenCommandGroups[runFunc] {")
+	g.w("if len(input) != 0 {")
+	g.w(`return &preparationError{original: errors.New("no matching targets found"), text: runFunc}`)
+	g.w("}")
+	g.w(`return &preparationError{original: errors.New("missing command"), text: runFunc}`)
+	g.w("}")
+
+	flagsID := "_"
+	if hasFlags {
+		flagsID = "parsedFlags"
+	}
+
+	g.w("%s, parsedArgs, err := parsecli.SliceAll(input, cligenValueFlags[runFunc])", flagsID)
 	g.checkPreparationError("", "")
 
+	if hasConfig {
+		g.w(`if configPaths, ok := parsedFlags["config"]; ok {`)
+		g.w("configPath = configPaths[len(configPaths)-1]")
+		g.w("}")
+		g.w("var parsedConfig map[string][]string")
+		g.w(`if configPath != "" {`)
+		g.w("parsedConfig, err = cligenLoadConfig(configPath, runFunc)")
+		g.checkPreparationError("", "")
+		g.w("}")
+	}
+
 	g.w("switch runFunc {")
 
 	g.w(`case "help":`)
 	{
-		g.w("var x string")
-		g.w(`if len(parsedArgs) > 0 {`)
-		g.w("x = parsedArgs[0]")
-		g.w("if x == \"help\" { x = \"\" } else { x = funcNames[x] }")
-		g.w("}")
+		g.w("x, _ := cligenFindCommand(parsedArgs)")
+		g.w("if x == \"help\" { x = \"\" }")
 		g.w("fmt.Println(funcHelps[x])")
 		g.w("return nil")
 	}
 
-	for f, finfo := range program.Functions {
+	for _, finfo := range program.Functions {
 
-		g.w(`case "%s":`, f)
+		g.w(`case %#v:`, finfo.UIName)
 
 		checkArgs(g, finfo)
+		checkFlags(g, finfo)
 		if err := callFunc(g, finfo); err != nil {
 			return nil, err
 		}
@@ -205,150 +299,237 @@ func File(program *parse.Program) ([]byte, error) {
 	g.w("return nil")
 	g.w("}")
 
-	fmt.Println(g.b.String())
+	for _, pattern := range g.patterns {
+		g.w("var %s = regexp.MustCompile(%#v)", g.patternIDs[pattern], pattern)
+	}
 
-	return format.Source(g.b.Bytes())
-}
+	// the header is written last so that the imports needed by everything else are known
+	header := newGenerator(g.ids)
 
-func checkArgs(g *generator, f *parse.Function) {
+	header.w("// Code generated by cligen. DO NOT EDIT.")
+	header.w("// See https://github.com/codemicro/cligen")
 
-	var numArgs int
-	for _, arg := range f.Signature.Argument {
-		if !arg.IsPointer {
-			numArgs += 1
-			continue
-		}
+	header.w("")
+
+	header.w("package %s", program.PackageName)
+
+	var imports []string
+	for x := range g.imports {
+		imports = append(imports, x)
 	}
+	sort.Strings(imports)
 
-	g.w(`if len(parsedArgs) < %d {`, numArgs)
-	g.returnPreparationError(f.UIName, "not enough arguments")
-	g.w("}")
-}
+	header.w("import (")
+	for _, i := range imports {
+		header.w(`"%s"`, i)
+	}
+	header.w(")")
 
-func callFunc(g *generator, f *parse.Function) error {
+	header.b.Write(g.b.Bytes())
 
-	var varIDs []string
-	var currentArgIndex int
+	return format.Source(header.b.Bytes())
+}
 
-	getSource := func(param *parse.Param) string {
-		if param.IsPointer {
-			return `parsedFlags["` + param.Name + `"]`
-		} else {
-			source := fmt.Sprintf("parsedArgs[%d]", currentArgIndex)
-			currentArgIndex += 1
-			return source
+// commandGroups returns the paths of every group of commands, in the order that they are first used.
+func commandGroups(functions []*parse.Function) []string {
+	var groups []string
+	seen := make(map[string]bool)
+	for _, finfo := range functions {
+		path := finfo.Path()
+		for i := 1; i < len(path); i += 1 {
+			group := strings.Join(path[:i], " ")
+			if !seen[strings.ToLower(group)] {
+				groups = append(groups, group)
+			}
+			seen[strings.ToLower(group)] = true
 		}
 	}
+	return groups
+}
 
-	checkProvided := func(g *generator, param *parse.Param, id string, block []byte) {
-		if !param.IsPointer {
-			g.b.Write(block)
-			return
+// writeConfigLoader writes the cligenLoadConfig function, which reads flag values for a command from a JSON config file with
+// a section for each command, for example `{"serve": {"port": 8080}}`.
+func writeConfigLoader(g *generator, program *parse.Program) {
+	g.addImport("encoding/json")
+
+	g.w("var cligenConfigFlags = map[string]map[string]bool{")
+	for _, finfo := range program.Functions {
+		var names []string
+		for _, arg := range finfo.Signature.Argument {
+			if arg.IsFlag() {
+				names = append(names, fmt.Sprintf("%#v: true,", flagName(arg)))
+			}
 		}
-		g.w(`if _, ok := parsedFlags["%s"]; !ok {`, param.Name)
-		g.w("%s = nil", id)
-		g.w("} else {")
-		g.b.Write(block)
+		g.w("%#v: {%s},", finfo.UIName, strings.Join(names, " "))
+	}
+	g.w("}")
+
+	g.w("func cligenLoadConfig(path, command string) (map[string][]string, error) {")
+	{
+		g.w("data, err := os.ReadFile(path)")
+		g.w("if err != nil { return nil, err }")
+
+		g.w("var sections map[string]map[string]json.RawMessage")
+		g.w("if err := json.Unmarshal(data, &sections); err != nil {")
+		g.w(`return nil, fmt.Errorf("invalid config file %%s: %%w", path, err)`)
 		g.w("}")
 
-	}
+		g.w("values := make(map[string][]string)")
+		g.w("for section, keys := range sections {")
+		{
+			g.w("name := funcNames[strings.ToLower(section)]")
+			g.w("known, ok := cligenConfigFlags[name]")
+			g.w("if !ok {")
+			g.w(`return nil, fmt.Errorf("unknown command %%#v in config file %%s", section, path)`)
+			g.w("}")
 
-	writeVar := func(g *generator, arg *parse.Param, id, t string) {
-		var pChar string
-		if arg.IsPointer {
-			pChar = "*"
+			g.w("for key, raw := range keys {")
+			{
+				g.w("if !known[strings.ToLower(key)] {")
+				g.w(`return nil, fmt.Errorf("unknown flag %%#v for command %%s in config file %%s", key, name, path)`)
+				g.w("}")
+
+				// values are converted back into strings so they can be handled in the same way as flags
+				g.w("var items []json.RawMessage")
+				g.w("if err := json.Unmarshal(raw, &items); err != nil { items = []json.RawMessage{raw} }")
+				g.w("for _, item := range items {")
+				{
+					g.w("var value interface{}")
+					g.w("if err := json.Unmarshal(item, &value); err != nil { return nil, err }")
+					g.w("switch value := value.(type) {")
+					g.w("case string:")
+					g.w("item = []byte(value)")
+					g.w("case float64, bool:")
+					g.w("default:")
+					g.w(`return nil, fmt.Errorf("invalid value for flag %%s of command %%s in config file %%s: must be a string, number or boolean", key, name, path)`)
+					g.w("}")
+					g.w("if name == command {")
+					g.w("values[strings.ToLower(key)] = append(values[strings.ToLower(key)], string(item))")
+					g.w("}")
+				}
+				g.w("}")
+			}
+			g.w("}")
 		}
-		g.w("var %s %s%s", id, pChar, t)
+		g.w("}")
+
+		g.w("return values, nil")
 	}
+	g.w("}")
+}
 
-	numConv := func(g *generator, arg *parse.Param, source, id, t string, fx string) {
-		writeVar(g, arg, id, t)
-		x := newGenerator()
-		tempID := nextIdentifier()
-		x.w("%s, err := strconv.%s(%s, 10, intSize)", tempID, fx, source)
-		x.checkPreparationError(f.UIName, "")
+func checkArgs(g *generator, f *parse.Function) {
 
-		if arg.IsPointer {
-			y := nextIdentifier()
-			x.w("%s := %s(%s)", y, t, tempID)
-			x.w("%s = &%s", id, y)
-		} else {
-			x.w("%s = %s(%s)", id, t, tempID)
+	var numArgs int
+	var hasTrailingSlice bool
+	for _, arg := range f.Signature.Argument {
+		if arg.IsFlag() {
+			continue
+		}
+		if arg.IsSlice {
+			hasTrailingSlice = true
+			continue
 		}
-		checkProvided(g, arg, id, x.b.Bytes())
+		numArgs += 1
 	}
 
-	for _, arg := range f.Signature.Argument {
-		id := nextIdentifier()
-		varIDs = append(varIDs, id)
-
-		source := getSource(arg)
+	g.w(`if len(parsedArgs) < %d {`, numArgs)
+	g.returnPreparationError(f.UIName, "not enough arguments")
+	g.w("}")
 
-		switch arg.Type {
-		case "int":
-			numConv(g, arg, source, id, "int", "ParseInt")
+	// a trailing slice takes any number of extra arguments
+	if !hasTrailingSlice {
+		g.w(`if len(parsedArgs) > %d {`, numArgs)
+		g.returnPreparationError(f.UIName, "too many arguments")
+		g.w("}")
+	}
+}
 
-		case "uint":
-			numConv(g, arg, source, id, "uint", "ParseUint")
+// hasFlagGroups returns true if f has any flag groups.
+func hasFlagGroups(f *parse.Function) bool {
+	return len(f.ExclusiveFlags) != 0 || len(f.AtLeastOneFlags) != 0
+}
 
-		case "float32":
-			writeVar(g, arg, id, "float32")
+// checkFlags writes code that checks that the flag groups of f are respected.
+func checkFlags(g *generator, f *parse.Function) {
+	for _, group := range f.ExclusiveFlags {
+		givenID := g.ids.next("givenFlags")
+		g.w("var %s []string", givenID)
+		for _, arg := range group {
+			g.w("if %s {", flagGivenCondition(g, arg))
+			g.w("%s = append(%s, %#v)", givenID, givenID, "--"+arg.Name)
+			g.w("}")
+		}
+		g.w("if len(%s) > 1 {", givenID)
+		g.w(`return &preparationError{original: fmt.Errorf("flags %%s cannot be used together", strings.Join(%s, ", ")), text: %#v}`, givenID, f.UIName)
+		g.w("}")
+	}
 
-			x := newGenerator()
+	for _, group := range f.AtLeastOneFlags {
+		var conditions, names []string
+		for _, arg := range group {
+			conditions = append(conditions, "!("+flagGivenCondition(g, arg)+")")
+			names = append(names, "--"+arg.Name)
+		}
+		g.w("if %s {", strings.Join(conditions, " && "))
+		g.returnPreparationError(f.UIName, "at least one of the flags %s must be given", strings.Join(names, ", "))
+		g.w("}")
+	}
+}
 
-			tempID := nextIdentifier()
-			x.w("%s, err := strconv.ParseFloat(%s, 32)", tempID, source)
-			x.checkPreparationError(f.UIName, "")
+func callFunc(g *generator, f *parse.Function) error {
 
-			if arg.IsPointer {
-				y := nextIdentifier()
-				x.w("%s := float32(%s)", y, tempID)
-				x.w("%s = &%s", id, y)
-			} else {
-				x.w("%s = float32(%s)", id, tempID)
-			}
+	var varIDs []string
+	var currentArgIndex int
+	var trailingSlice *parse.Param
 
-			checkProvided(g, arg, id, x.b.Bytes())
+	for _, arg := range f.Signature.Argument {
+		id := g.ids.next("arg", arg.Name)
+		if arg.IsVariadic {
+			varIDs = append(varIDs, id+"...")
+		} else {
+			varIDs = append(varIDs, id)
+		}
 
-		case "bool":
-			writeVar(g, arg, id, "bool")
+		typeName := arg.Type
+		if arg.IsSlice {
+			typeName = "[]" + typeName
+		}
 
-			x := newGenerator()
+		if arg.TypePackage != "" {
+			g.addImport(arg.TypePackage)
+		}
 
-			var t string
-			if arg.IsPointer {
-				t = nextIdentifier()
-			} else {
-				t = id
-			}
+		if arg.IsPointer {
+			g.w("var %s *%s", id, typeName)
+		} else {
+			g.w("var %s %s", id, typeName)
+		}
 
-			x.w("%s, err := strconv.ParseBool(%s)", t, source)
-			x.checkPreparationError(f.UIName, "")
+		if !arg.IsFlag() && trailingSlice != nil {
+			return fmt.Errorf("slice argument %s in function %s must be the last positional argument", trailingSlice.Name, f.Name)
+		}
 
-			if arg.IsPointer {
-				y := nextIdentifier()
-				x.w("%s := %s", y, t)
-				x.w("%s = &%s", id, y)
+		switch {
+		case arg.IsFlag():
+			if err := readFlag(g, f, arg, id, typeName); err != nil {
+				return err
 			}
 
-			checkProvided(g, arg, id, x.b.Bytes())
-
-		case "string":
-			writeVar(g, arg, id, "string")
-
-			x := newGenerator()
-
-			if arg.IsPointer {
-				y := nextIdentifier()
-				x.w("%s := %s", y, source)
-				x.w("%s = &%s", id, y)
-			} else {
-				x.w("%s = %s", id, source)
+		case arg.IsSlice:
+			// soaks up all remaining arguments
+			trailingSlice = arg
+			if err := appendValues(g, f, arg, id, fmt.Sprintf("parsedArgs[%d:]", currentArgIndex)); err != nil {
+				return err
 			}
 
-			checkProvided(g, arg, id, x.b.Bytes())
 		default:
-			return fmt.Errorf("unknown argument data type of %s (%s) in function %s", arg.Type, arg.Name, f.Name)
+			expr, err := convertValue(g, f, arg, id, fmt.Sprintf("parsedArgs[%d]", currentArgIndex))
+			if err != nil {
+				return err
+			}
+			currentArgIndex += 1
+			g.w("%s = %s", id, expr)
 		}
 
 	}
@@ -362,7 +543,7 @@ func callFunc(g *generator, f *parse.Function) error {
 			if errID != "" {
 				return errors.New("cannot have more than one error return")
 			}
-			x = nextIdentifier()
+			x = g.ids.next("cmdErr")
 			errID = x
 		} else {
 			x = "_"
@@ -371,71 +552,317 @@ func callFunc(g *generator, f *parse.Function) error {
 	}
 
 	var returnBlock string
-	if len(returns) != 0 {
+	if errID != "" {
 		returnBlock = strings.Join(returns, ", ") + " := "
+	} else if len(returns) != 0 {
+		returnBlock = strings.Join(returns, ", ") + " = "
 	}
 
 	g.w("%s%s(%s)", returnBlock, f.Name, strings.Join(varIDs, ", "))
 
-	if len(returns) != 0 {
+	if errID != "" {
 		g.checkRuntimeError(errID)
 	}
 
 	return nil
 }
 
-type nameDesc struct{ Name, Description string }
+// readFlag writes code that sets the variable in id from the values given for the flag arg. If the flag is not given,
+// the environment variable of arg is used, followed by the config file and then its default value.
+func readFlag(g *generator, f *parse.Function, arg *parse.Param, id, typeName string) error {
+	valuesID := g.ids.next(id, "values")
+	g.w(`%s, ok := parsedFlags[%#v]`, valuesID, flagName(arg))
+
+	if arg.Short != "" {
+		shortID := g.ids.next(id, "shortValues")
+		g.w("if %s, found := parsedFlags[%#v]; found {", shortID, strings.ToLower(arg.Short))
+		// values given using the long name take precedence
+		g.w("%s, ok = append(%s, %s...), true", valuesID, shortID, valuesID)
+		g.w("}")
+	}
 
-func makeHelpTexts(functions map[string]*parse.Function) map[string]string {
-	o := make(map[string]string)
-	for functionName, function := range functions {
+	if arg.Env != "" {
+		envID := g.ids.next(id, "env")
+		// empty environment variables are treated as if they are not set
+		g.w("if !ok {")
+		g.w("if %s := os.Getenv(%#v); %s != \"\" {", envID, arg.Env, envID)
+		g.w("%s, ok = []string{%s}, true", valuesID, envID)
+		g.w("}")
+		g.w("}")
+	}
 
-		var args, flags []string
-		var opts []nameDesc
-		for _, x := range function.Signature.Argument {
+	if g.hasConfig {
+		g.w("if !ok {")
+		g.w("%s, ok = parsedConfig[%#v]", valuesID, flagName(arg))
+		g.w("}")
+	}
 
-			fx := fmt.Sprintf("[--%s]", x.Name)
-			fy := fmt.Sprintf("<%s>", x.Name)
+	if arg.HasDefault {
+		g.w("if !ok {")
+		g.w("%s, ok = []string{%#v}, true", valuesID, arg.Default)
+		g.w("}")
+	}
 
-			if x.IsPointer {
-				flags = append(flags, fx)
-				opts = append(opts, nameDesc{
-					Name: x.Name,
-				})
-			} else {
-				args = append(args, fy)
-			}
+	if arg.Required {
+		g.w("if !ok {")
+		g.returnPreparationError(f.UIName, "flag --%s is required", arg.Name)
+		g.w("}")
+	}
+
+	g.w("if ok {")
+
+	if arg.IsSlice {
+		sliceID := g.ids.next(id, "slice")
+		g.w("var %s %s", sliceID, typeName)
+		if err := appendValues(g, f, arg, sliceID, valuesID); err != nil {
+			return err
 		}
+		g.w("%s = &%s", id, sliceID)
+	} else {
+		// if a flag is given more than once, use the last value
+		expr, err := convertValue(g, f, arg, id, fmt.Sprintf("%s[len(%s)-1]", valuesID, valuesID))
+		if err != nil {
+			return err
+		}
+		if arg.IsPointer {
+			valueID := g.ids.next(id, "value")
+			g.w("%s := %s", valueID, expr)
+			g.w("%s = &%s", id, valueID)
+		} else {
+			g.w("%s = %s", id, expr)
+		}
+	}
 
-		o[functionName] = helpTextString("%s", function.Description, function.UIName, args, flags, "flags", opts)
+	g.w("}")
+	return nil
+}
+
+// appendValues writes code that converts every string in source and appends the result to the slice in id.
+func appendValues(g *generator, f *parse.Function, arg *parse.Param, id, source string) error {
+	rawID := g.ids.next(id, "raw")
+	g.w("for _, %s := range %s {", rawID, source)
+	expr, err := convertValue(g, f, arg, id, rawID)
+	if err != nil {
+		return err
 	}
+	g.w("%s = append(%s, %s)", id, id, expr)
+	g.w("}")
+	return nil
+}
 
-	// make overall help text
-	var opts []nameDesc
-	for _, finfo := range functions {
-		opts = append(opts, nameDesc{Name: finfo.UIName, Description: finfo.Description})
+// numericTypes maps the names of basic numeric types to the strconv function used to parse them and the bit size that
+// values must fit into.
+var numericTypes = map[string]struct {
+	function  string
+	bitSize   string
+	isInteger bool
+}{
+	"int":        {"ParseInt", "intSize", true},
+	"int8":       {"ParseInt", "8", true},
+	"int16":      {"ParseInt", "16", true},
+	"int32":      {"ParseInt", "32", true},
+	"int64":      {"ParseInt", "64", true},
+	"uint":       {"ParseUint", "intSize", true},
+	"uint8":      {"ParseUint", "8", true},
+	"uint16":     {"ParseUint", "16", true},
+	"uint32":     {"ParseUint", "32", true},
+	"uint64":     {"ParseUint", "64", true},
+	"uintptr":    {"ParseUint", "intSize", true},
+	"float32":    {"ParseFloat", "32", false},
+	"float64":    {"ParseFloat", "64", false},
+	"complex64":  {"ParseComplex", "64", false},
+	"complex128": {"ParseComplex", "128", false},
+}
+
+// convertValue writes code that converts the string in source to the type of arg, and returns an expression that
+// evaluates to the converted value.
+func convertValue(g *generator, f *parse.Function, arg *parse.Param, id, source string) (string, error) {
+	parsedID := g.ids.next(id, "parsed")
+
+	switch arg.Type {
+	case "time.Duration":
+		g.w("%s, err := time.ParseDuration(%s)", parsedID, source)
+		checkConversionError(g, f, arg, source, "err")
+		checkBounds(g, f, arg, parsedID, source)
+		return parsedID, nil
+
+	case "time.Time":
+		layout := "time.RFC3339"
+		if arg.Layout != "" {
+			layout = fmt.Sprintf("%#v", arg.Layout)
+		}
+
+		g.w("%s, err := time.Parse(%s, %s)", parsedID, layout, source)
+		checkConversionError(g, f, arg, source, "err")
+		return parsedID, nil
+	}
+
+	if arg.IsTextUnmarshaler {
+		g.w("var %s %s", parsedID, arg.Type)
+		g.w("if err := %s.UnmarshalText([]byte(%s)); err != nil {", parsedID, source)
+		returnConversionError(g, f, arg, source, "err")
+		g.w("}")
+		return parsedID, nil
 	}
-	o[""] = helpTextString("%s", "", "<command>", []string{"[<args>]"}, []string{"[<flags>]"}, "commands", opts)
 
-	return o
+	if numeric, ok := numericTypes[arg.Kind]; ok {
+		g.addImport("strconv")
+		if numeric.isInteger {
+			// base 0 allows hex, octal and binary literals to be used
+			g.w("%s, err := strconv.%s(%s, 0, %s)", parsedID, numeric.function, source, numeric.bitSize)
+		} else {
+			g.w("%s, err := strconv.%s(%s, %s)", parsedID, numeric.function, source, numeric.bitSize)
+		}
+		// strconv errors repeat the value, so only the reason is kept
+		checkConversionError(g, f, arg, source, "err.(*strconv.NumError).Err")
+		checkBounds(g, f, arg, parsedID, source)
+		return fmt.Sprintf("%s(%s)", arg.Type, parsedID), nil
+	}
+
+	switch arg.Kind {
+	case "bool":
+		g.addImport("strconv")
+		g.w("%s, err := strconv.ParseBool(%s)", parsedID, source)
+		checkConversionError(g, f, arg, source, "err.(*strconv.NumError).Err")
+		return convertType(arg, parsedID), nil
+
+	case "string":
+		if len(arg.Choices) != 0 {
+			checkChoices(g, f, arg, source)
+		}
+		if arg.Pattern != "" {
+			checkPattern(g, f, arg, source)
+		}
+		return convertType(arg, source), nil
+
+	default:
+		return "", fmt.Errorf("unknown argument data type of %s (%s) in function %s", arg.Type, arg.Name, f.Name)
+	}
+}
+
+// checkConversionError writes code that returns an error naming arg if the conversion of source failed with err. cause
+// is the expression for the underlying error.
+func checkConversionError(g *generator, f *parse.Function, arg *parse.Param, source, cause string) {
+	g.w("if err != nil {")
+	returnConversionError(g, f, arg, source, cause)
+	g.w("}")
+}
+
+func returnConversionError(g *generator, f *parse.Function, arg *parse.Param, source, cause string) {
+	g.w(`return &preparationError{original: fmt.Errorf("invalid value %%#v for %%s: %%w", %s, %#v, %s), text: %#v}`,
+		source, argumentName(arg), cause, f.UIName)
 }
 
-func helpTextString(execName, description, command string, args, flags []string, optName string, opts []nameDesc) string {
-	if description != "" {
-		description += "\n"
+// checkChoices writes code that checks that the string in source is one of the choices of arg.
+func checkChoices(g *generator, f *parse.Function, arg *parse.Param, source string) {
+	var cases []string
+	for _, choice := range arg.Choices {
+		cases = append(cases, fmt.Sprintf("%#v", choice))
 	}
 
-	var optsString string
-	if len(opts) != 0 {
-		optsString += "Available " + optName + ":\n"
-		var x []string
-		for _, opt := range opts {
-			x = append(x, fmt.Sprintf("    %s  %s", opt.Name, opt.Description))
+	g.w("switch %s {", source)
+	g.w("case %s:", strings.Join(cases, ", "))
+	g.w("default:")
+	g.w(`return &preparationError{original: fmt.Errorf("invalid value %%#v for %%s, must be one of %%s", %s, %#v, %#v), text: %#v}`,
+		source, argumentName(arg), strings.Join(arg.Choices, ", "), f.UIName)
+	g.w("}")
+}
+
+// checkBounds writes code that checks that the number in parsedID, which was parsed from source, is within the minimum
+// and maximum values of arg.
+func checkBounds(g *generator, f *parse.Function, arg *parse.Param, parsedID, source string) {
+	for _, bound := range []struct {
+		value, operator, description string
+	}{
+		{arg.Min, "<", "at least"},
+		{arg.Max, ">", "at most"},
+	} {
+		if bound.value == "" {
+			continue
 		}
-		optsString += strings.Join(x, "\n")
+		g.w("if %s %s %s {", parsedID, bound.operator, numberLiteral(arg, bound.value))
+		g.w(`return &preparationError{original: fmt.Errorf("invalid value %%#v for %%s, must be %%s", %s, %#v, %#v), text: %#v}`,
+			source, argumentName(arg), bound.description+" "+bound.value, f.UIName)
+		g.w("}")
+	}
+}
+
+// numberLiteral returns value, which must be a valid value for arg, as a Go literal that can be compared with the
+// result of parsing values for arg.
+func numberLiteral(arg *parse.Param, value string) string {
+	switch {
+	case arg.Type == "time.Duration":
+		d, _ := time.ParseDuration(value)
+		return strconv.FormatInt(int64(d), 10)
+	case strings.HasPrefix(arg.Kind, "int"):
+		i, _ := strconv.ParseInt(value, 0, 64)
+		return strconv.FormatInt(i, 10)
+	case strings.HasPrefix(arg.Kind, "uint"):
+		u, _ := strconv.ParseUint(value, 0, 64)
+		return strconv.FormatUint(u, 10)
+	default:
+		x, _ := strconv.ParseFloat(value, 64)
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+}
+
+// checkPattern writes code that checks that the string in source matches the pattern of arg.
+func checkPattern(g *generator, f *parse.Function, arg *parse.Param, source string) {
+	g.addImport("regexp")
+
+	id, found := g.patternIDs[arg.Pattern]
+	if !found {
+		// patterns are compiled once, when the program starts
+		id = g.ids.next("cligenPattern")
+		g.patterns = append(g.patterns, arg.Pattern)
+		g.patternIDs[arg.Pattern] = id
+	}
+
+	g.w("if !%s.MatchString(%s) {", id, source)
+	g.w(`return &preparationError{original: fmt.Errorf("invalid value %%#v for %%s, must match %%s", %s, %#v, %#v), text: %#v}`,
+		source, argumentName(arg), arg.Pattern, f.UIName)
+	g.w("}")
+}
+
+// argumentName returns the name of arg as used in error messages.
+func argumentName(arg *parse.Param) string {
+	if arg.IsFlag() {
+		return "flag --" + arg.Name
+	}
+	return "argument " + arg.Name
+}
+
+// convertType returns an expression that converts expr, which is of the type named by arg.Kind, to the type of arg.
+func convertType(arg *parse.Param, expr string) string {
+	if arg.Type == arg.Kind {
+		return expr
+	}
+	return fmt.Sprintf("%s(%s)", arg.Type, expr)
+}
+
+// flagName returns the name that arg is looked up with in the flags returned by parsecli.
+func flagName(arg *parse.Param) string {
+	return strings.ToLower(arg.Name)
+}
 
-		optsString = "\n\n" + optsString
+// flagGivenCondition returns an expression that is true if the flag for arg is given, either on the command line, using
+// its environment variable or in the config file.
+func flagGivenCondition(g *generator, arg *parse.Param) string {
+	x := fmt.Sprintf("cligenFlagGiven(parsedFlags, %#v", flagName(arg))
+	if arg.Short != "" {
+		x += fmt.Sprintf(", %#v", strings.ToLower(arg.Short))
+	}
+	x += ")"
+	if arg.Env != "" {
+		x += fmt.Sprintf(` || os.Getenv(%#v) != ""`, arg.Env)
 	}
+	if g.hasConfig {
+		x += fmt.Sprintf(" || len(parsedConfig[%#v]) != 0", flagName(arg))
+	}
+	return x
+}
 
-	return fmt.Sprintf("%sUsage: %s %s %s %s%s", description, execName, command, strings.Join(flags, " "), strings.Join(args, " "), optsString)
+// takesValue returns true if arg is a flag that needs a value to be given, as opposed to a boolean switch.
+func takesValue(arg *parse.Param) bool {
+	return arg.IsFlag() && arg.Kind != "bool"
 }
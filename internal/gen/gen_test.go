@@ -0,0 +1,556 @@
+package gen
+
+import (
+	"bytes"
+	"github.com/codemicro/cligen/internal/parse"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileDeterministic(t *testing.T) {
+	program := &parse.Program{
+		PackageName: "example",
+		Functions: []*parse.Function{
+			{
+				Name:   "Add",
+				UIName: "Add",
+				Signature: &parse.Signature{
+					Argument: []*parse.Param{
+						{Name: "a", Type: "int", Kind: "int"},
+						{Name: "b", Type: "int", Kind: "int"},
+						{Name: "verbose", Type: "bool", Kind: "bool", IsPointer: true},
+					},
+					Return: []*parse.Param{{Type: "error"}},
+				},
+			},
+		},
+	}
+
+	first, err := File(program)
+	if err != nil {
+		t.Fatalf("File() error = %v", err)
+	}
+
+	second, err := File(program)
+	if err != nil {
+		t.Fatalf("File() error = %v", err)
+	}
+
+	if !bytes.Equal(first, second) {
+		t.Errorf("File() returned different output for the same program:\n%s\n%s", first, second)
+	}
+}
+
+// generateCompact generates a runner for functions, with all whitespace collapsed into single spaces so that tests
+// aren't affected by alignment.
+func generateCompact(t *testing.T, functions ...*parse.Function) string {
+	t.Helper()
+	out, err := File(&parse.Program{PackageName: "example", Functions: functions})
+	if err != nil {
+		t.Fatalf("File() error = %v", err)
+	}
+	return strings.Join(strings.Fields(string(out)), " ")
+}
+
+func TestFileFlagChecks(t *testing.T) {
+	var (
+		out  = &parse.Param{Name: "out", Type: "string", Kind: "string", IsPointer: true}
+		json = &parse.Param{Name: "json", Type: "bool", Kind: "bool", IsPointer: true}
+		yaml = &parse.Param{Name: "yaml", Type: "bool", Kind: "bool", IsPointer: true, Short: "y"}
+	)
+	requiredOut := *out
+	requiredOut.Required = true
+	envYaml := *yaml
+	envYaml.Env = "TOOL_YAML"
+
+	tests := []struct {
+		name     string
+		function *parse.Function
+		want     []string
+	}{
+		{
+			name: "required",
+			function: &parse.Function{
+				Signature: &parse.Signature{Argument: []*parse.Param{&requiredOut}},
+			},
+			want: []string{
+				`argOutValues, ok := parsedFlags["out"] if !ok { return &preparationError{original: errors.New("flag --out is required"), text: "export"} }`,
+			},
+		},
+		{
+			name: "exclusive",
+			function: &parse.Function{
+				Signature:      &parse.Signature{Argument: []*parse.Param{out, json, yaml}},
+				ExclusiveFlags: [][]*parse.Param{{json, yaml}},
+			},
+			want: []string{
+				`func cligenFlagGiven(flags map[string][]string, names ...string) bool {`,
+				`var givenFlags []string if cligenFlagGiven(parsedFlags, "json") { givenFlags = append(givenFlags, "--json") } if cligenFlagGiven(parsedFlags, "yaml", "y") { givenFlags = append(givenFlags, "--yaml") }`,
+				`if len(givenFlags) > 1 { return &preparationError{original: fmt.Errorf("flags %s cannot be used together", strings.Join(givenFlags, ", ")), text: "export"} }`,
+			},
+		},
+		{
+			name: "at least one",
+			function: &parse.Function{
+				Signature:       &parse.Signature{Argument: []*parse.Param{out, json, &envYaml}},
+				AtLeastOneFlags: [][]*parse.Param{{json, &envYaml}},
+			},
+			want: []string{
+				`if !(cligenFlagGiven(parsedFlags, "json")) && !(cligenFlagGiven(parsedFlags, "yaml", "y") || os.Getenv("TOOL_YAML") != "") {`,
+				`return &preparationError{original: errors.New("at least one of the flags --json, --yaml must be given"), text: "export"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.function.Name = "Export"
+			tt.function.UIName = "export"
+			got := generateCompact(t, tt.function)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("File() output does not contain %s\n%s", want, got)
+				}
+			}
+		})
+	}
+
+	runner := buildRunner(t, `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if err := Start(os.Args[1:]); err != nil {
+		fmt.Println(err)
+	}
+}
+
+//cligen:cmd export
+//cligen:required out
+//cligen:short yaml y
+//cligen:exclusive json yaml
+//cligen:atleastone json yaml
+func Export(out *string, json, yaml *bool) {
+	fmt.Println(*out, json != nil, yaml != nil)
+}
+`)
+
+	runTests := []struct {
+		args   []string
+		want   string
+		wantOK bool
+	}{
+		{args: []string{"export", "--out", "a", "--json"}, want: "a true false\n", wantOK: true},
+		{args: []string{"export", "--out", "a", "-y"}, want: "a false true\n", wantOK: true},
+		{args: []string{"export", "--json"}, want: "flag --out is required\n"},
+		{args: []string{"export", "--out", "a"}, want: "at least one of the flags --json, --yaml must be given\n"},
+		{args: []string{"export", "--out", "a", "--json", "-y"}, want: "flags --json, --yaml cannot be used together\n"},
+	}
+	for _, tt := range runTests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			out, ok := runRunner(t, runner, tt.args...)
+			if ok != tt.wantOK || !strings.HasPrefix(out, tt.want) {
+				t.Errorf("runner output = %q, success = %v, want %q, success = %v", out, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// buildRunner writes src as the only file of a main package in a new module, generates a runner for it and builds it,
+// returning the path of the executable.
+func buildRunner(t *testing.T, src string) string {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping build of generated runner in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goSum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/runner\n\ngo 1.22.0\n\nrequire github.com/codemicro/cligen v0.0.0\n\nreplace github.com/codemicro/cligen => " + root + "\n",
+		"go.sum":  string(goSum),
+		"main.go": src,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	program, err := parse.Directory(dir)
+	if err != nil {
+		t.Fatalf("parse.Directory() error = %v", err)
+	}
+	generated, err := File(program)
+	if err != nil {
+		t.Fatalf("File() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "runner.cligen.go"), generated, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(goBin, "build", "-o", "runner", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOPROXY=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building generated runner failed: %v\n%s\n%s", err, out, generated)
+	}
+
+	return filepath.Join(dir, "runner")
+}
+
+// runRunner runs the executable built by buildRunner with the given arguments, returning everything it printed and
+// whether it exited successfully.
+func runRunner(t *testing.T, runner string, args ...string) (string, bool) {
+	t.Helper()
+	out, err := exec.Command(runner, args...).CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatal(err)
+	}
+	return string(out), err == nil
+}
+
+func TestFileConfigWithoutFlags(t *testing.T) {
+	runner := buildRunner(t, `//cligen:config
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if err := Start(os.Args[1:]); err != nil {
+		fmt.Println(err)
+	}
+}
+
+//cligen:cmd hello
+func Hello(name string) {
+	fmt.Println("hello", name)
+}
+`)
+
+	if out, ok := runRunner(t, runner, "hello", "world"); !ok || out != "hello world\n" {
+		t.Errorf("runner output = %q, success = %v", out, ok)
+	}
+}
+
+func TestFileConfig(t *testing.T) {
+	runner := buildRunner(t, `//cligen:config
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if err := Start(os.Args[1:]); err != nil {
+		fmt.Println(err)
+	}
+}
+
+//cligen:cmd db migrate
+//cligen:default steps 1
+func Migrate(steps int, name *string) {
+	fmt.Println(steps, *name)
+}
+`)
+
+	config := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(config, []byte(`{"db migrate": {"steps": 3, "name": "from config"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"--config", config, "db", "migrate"}, want: "3 from config\n"},
+		{args: []string{"--config=" + config, "db", "migrate", "--steps", "4"}, want: "4 from config\n"},
+		{args: []string{"db", "migrate", "--config", config, "--name=x"}, want: "3 x\n"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if out, ok := runRunner(t, runner, tt.args...); !ok || out != tt.want {
+				t.Errorf("runner output = %q, success = %v, want %q", out, ok, tt.want)
+			}
+		})
+	}
+
+	unknown := filepath.Join(t.TempDir(), "unknown.json")
+	if err := os.WriteFile(unknown, []byte(`{"db migrate": {"bogus": 1}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, ok := runRunner(t, runner, "--config", unknown, "db", "migrate"); ok || !strings.Contains(out, `unknown flag "bogus"`) {
+		t.Errorf("runner output = %q, success = %v, want unknown flag error", out, ok)
+	}
+}
+
+func TestFileCommandTree(t *testing.T) {
+	got := generateCompact(t,
+		&parse.Function{Name: "Up", UIName: "db migrate up", Aliases: []string{"u"}, Signature: &parse.Signature{}},
+		&parse.Function{Name: "Seed", UIName: "DB seed", Description: "Seed the database.", Signature: &parse.Signature{}},
+		&parse.Function{Name: "Version", UIName: "version", Aliases: []string{"v"}, Signature: &parse.Signature{}},
+	)
+
+	for _, want := range []string{
+		`"db migrate up": "db migrate up", "db migrate u": "db migrate up",`,
+		`"db seed": "DB seed",`,
+		`"version": "version", "v": "version",`,
+		`"db": "db", "db migrate": "db migrate", }`,
+		`var cligenCommandGroups = map[string]bool{ "db": true, "db migrate": true, }`,
+		`"db": fmt.Sprintf("Usage: %s db <command> [<flags>] [<args>]\n\nAvailable commands:\n migrate ...\n seed Seed the database.", execName),`,
+		`"db migrate": fmt.Sprintf("Usage: %s db migrate <command> [<flags>] [<args>]\n\nAvailable commands:\n up, u", execName),`,
+		`case "db migrate up":`,
+		`case "DB seed":`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("File() output does not contain %s\n%s", want, got)
+		}
+	}
+
+	runner := buildRunner(t, `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if err := Start(os.Args[1:]); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Up applies migrations.
+//cligen:cmd db migrate up
+//cligen:alias u
+func Up(steps int) {
+	fmt.Println("up", steps)
+}
+
+// Seed seeds the database.
+//cligen:cmd db seed
+//cligen:description Fill the database
+func Seed() {
+	fmt.Println("seed")
+}
+
+// Version prints the version.
+//cligen:alias v
+func Version() {
+	fmt.Println("version")
+}
+`)
+
+	tests := []struct {
+		args   []string
+		want   string
+		wantOK bool
+	}{
+		{args: []string{"db", "migrate", "up", "2"}, want: "up 2\n", wantOK: true},
+		{args: []string{"DB", "Migrate", "U", "3"}, want: "up 3\n", wantOK: true},
+		{args: []string{"db", "seed"}, want: "seed\n", wantOK: true},
+		{args: []string{"v"}, want: "version\n", wantOK: true},
+		{args: []string{"help", "db"}, want: "Usage: " + runner + " db <command>", wantOK: true},
+		{args: []string{"help", "db", "migrate", "u"}, want: "Up applies migrations.\n\nAliases: u\n\nUsage: " + runner + " db migrate up <steps>", wantOK: true},
+		{args: []string{"help", "db", "seed"}, want: "Fill the database\n\nUsage: " + runner + " db seed", wantOK: true},
+		{args: []string{"help", "bogus"}, want: "Usage: " + runner + " <command>", wantOK: true},
+		{args: []string{"db"}, want: "missing command\nRun `" + runner + " help db`"},
+		{args: []string{"db", "bogus"}, want: "no matching targets found\nRun `" + runner + " help db`"},
+		{args: []string{"bogus"}, want: "no matching targets found\nRun `" + runner + " help`"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			out, ok := runRunner(t, runner, tt.args...)
+			if ok != tt.wantOK || !strings.HasPrefix(out, tt.want) {
+				t.Errorf("runner output = %q, success = %v, want %q, success = %v", out, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestFileConversionErrors(t *testing.T) {
+	runner := buildRunner(t, `package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+func main() {
+	if err := Start(os.Args[1:]); err != nil {
+		fmt.Println(err)
+	}
+}
+
+//cligen:cmd wait
+func Wait(timeout time.Duration, retries *int, force *bool, at *time.Time) {
+	fmt.Println(timeout, *retries, *force, at)
+}
+`)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"wait", "soon"}, want: `invalid value "soon" for argument timeout: time: invalid duration "soon"`},
+		{args: []string{"wait", "--retries", "x", "1s"}, want: `invalid value "x" for flag --retries: invalid syntax`},
+		{args: []string{"wait", "--force=maybe", "1s"}, want: `invalid value "maybe" for flag --force: invalid syntax`},
+		{args: []string{"wait", "--at", "today", "1s"}, want: `invalid value "today" for flag --at: parsing time`},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			out, ok := runRunner(t, runner, tt.args...)
+			if ok || !strings.HasPrefix(out, tt.want) {
+				t.Errorf("runner output = %q, success = %v, want %q", out, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilePattern(t *testing.T) {
+	got := generateCompact(t, &parse.Function{
+		Name:   "Tag",
+		UIName: "tag",
+		Signature: &parse.Signature{Argument: []*parse.Param{
+			{Name: "name", Type: "string", Kind: "string", Pattern: "^[a-z]+$"},
+			{Name: "other", Type: "string", Kind: "string", Pattern: "^[a-z]+$"},
+			{Name: "tags", Type: "string", Kind: "string", IsSlice: true, Pattern: "^#"},
+		}},
+	})
+
+	for _, want := range []string{
+		`if !cligenPattern.MatchString(parsedArgs[0]) {`,
+		`if !cligenPattern.MatchString(parsedArgs[1]) {`,
+		`if !cligenPattern2.MatchString(argTagsRaw) {`,
+		`var cligenPattern = regexp.MustCompile("^[a-z]+$") var cligenPattern2 = regexp.MustCompile("^#")`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("File() output does not contain %s\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "regexp.MustCompile") != 2 {
+		t.Errorf("File() output compiles patterns more than once\n%s", got)
+	}
+}
+
+func TestFileQuotesNames(t *testing.T) {
+	name := &parse.Param{Name: `n"a`, Type: "string", Kind: "string", IsPointer: true, Required: true}
+	other := &parse.Param{Name: `o"b`, Type: "bool", Kind: "bool", IsPointer: true}
+
+	got := generateCompact(t, &parse.Function{
+		Name:            "Hello",
+		UIName:          `a"b`,
+		Signature:       &parse.Signature{Argument: []*parse.Param{name, other}},
+		AtLeastOneFlags: [][]*parse.Param{{name, other}},
+	})
+
+	for _, want := range []string{
+		`case "a\"b":`,
+		`errors.New("flag --n\"a is required"), text: "a\"b"}`,
+		`errors.New("at least one of the flags --n\"a, --o\"b must be given")`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("File() output does not contain %s\n%s", want, got)
+		}
+	}
+}
+
+func TestFileNameClashes(t *testing.T) {
+	// names the runner declared at package level before they were prefixed
+	runner := buildRunner(t, `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if err := Start(os.Args[1:]); err != nil {
+		fmt.Println(err)
+	}
+}
+
+var commandGroups = []string{"db"}
+
+var valueFlags = map[string]bool{}
+
+func findCommand() string {
+	return "migrate"
+}
+
+func flagGiven() bool {
+	return true
+}
+
+//cligen:cmd db migrate
+//cligen:atleastone steps all
+func Migrate(steps *int, all *bool) {
+	fmt.Println(findCommand(), commandGroups[0], len(valueFlags), flagGiven(), *steps)
+}
+`)
+
+	if out, ok := runRunner(t, runner, "db", "migrate", "--steps", "2"); !ok || out != "migrate db 0 true 2\n" {
+		t.Errorf("runner output = %q, success = %v", out, ok)
+	}
+}
+
+func Test_identifiers_next(t *testing.T) {
+	ids := newIdentifiers("argB")
+
+	tests := []struct {
+		parts []string
+		want  string
+	}{
+		{parts: []string{"arg", "a"}, want: "argA"},
+		{parts: []string{"arg", "a"}, want: "argA2"},
+		{parts: []string{"arg", "b"}, want: "argB2"},
+		{parts: []string{"arg", ""}, want: "arg"},
+		{parts: []string{"err"}, want: "err2"},
+		{parts: []string{"arg", "dry-run"}, want: "argDryRun"},
+		{parts: []string{"arg", "dry-run", "values"}, want: "argDryRunValues"},
+	}
+	for _, tt := range tests {
+		if got := ids.next(tt.parts...); got != tt.want {
+			t.Errorf("next(%#v) = %v, want %v", tt.parts, got, tt.want)
+		}
+	}
+}
+
+func Test_commandGroups(t *testing.T) {
+	functions := []*parse.Function{
+		{UIName: "db migrate up"},
+		{UIName: "version"},
+		{UIName: "DB migrate down"},
+		{UIName: "db seed"},
+		{UIName: "cache clear"},
+	}
+
+	got := commandGroups(functions)
+	want := []string{"db", "db migrate", "cache"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("commandGroups() = %#v, want %#v", got, want)
+	}
+}
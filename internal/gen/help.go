@@ -0,0 +1,213 @@
+package gen
+
+import (
+	"fmt"
+	"github.com/codemicro/cligen/internal/parse"
+	"strings"
+	"text/tabwriter"
+)
+
+// helpSection is a titled table in help text, for example the list of flags a command takes.
+type helpSection struct {
+	title string
+	rows  [][]string
+}
+
+func makeHelpTexts(functions []*parse.Function, hasConfig bool) map[string]string {
+	o := make(map[string]string)
+	for _, function := range functions {
+
+		var hasShortFlags bool
+		for _, x := range function.Signature.Argument {
+			if x.Short != "" {
+				hasShortFlags = true
+			}
+		}
+
+		var args, flags []string
+		argsSection := &helpSection{title: "Arguments"}
+		flagsSection := &helpSection{title: "Flags"}
+		envSection := &helpSection{title: "Environment variables"}
+		for _, x := range function.Signature.Argument {
+
+			fx := fmt.Sprintf("[--%s]", x.Name)
+			if x.Required {
+				fx = "--" + x.Name
+			}
+			fy := fmt.Sprintf("<%s>", x.Name)
+			if x.IsSlice {
+				fx += "..."
+				fy += "..."
+			}
+
+			description := x.Description
+			if len(x.Choices) != 0 {
+				description = joinNonEmpty(description, fmt.Sprintf("(one of: %s)", strings.Join(x.Choices, ", ")))
+			}
+			if x.Min != "" {
+				description = joinNonEmpty(description, fmt.Sprintf("(at least %s)", x.Min))
+			}
+			if x.Max != "" {
+				description = joinNonEmpty(description, fmt.Sprintf("(at most %s)", x.Max))
+			}
+			if x.Pattern != "" {
+				description = joinNonEmpty(description, fmt.Sprintf("(matching %s)", x.Pattern))
+			}
+
+			if x.IsFlag() {
+				if x.HasDefault {
+					description = joinNonEmpty(description, fmt.Sprintf("(default: %s)", x.Default))
+				}
+				if x.Required {
+					description = joinNonEmpty(description, "(required)")
+				}
+
+				if x.Env != "" {
+					usedFor := "used for --" + x.Name + " if the flag is not given"
+					if x.HasDefault {
+						usedFor += ", instead of the default"
+					}
+					envSection.rows = append(envSection.rows, []string{x.Env, usedFor})
+				}
+
+				flags = append(flags, fx)
+				flagsSection.rows = append(flagsSection.rows, []string{flagDescription(x, hasShortFlags), typeDescription(x), description})
+			} else {
+				args = append(args, fy)
+				argsSection.rows = append(argsSection.rows, []string{x.Name, typeDescription(x), description})
+			}
+		}
+
+		if hasConfig {
+			flagsSection.rows = append(flagsSection.rows, []string{
+				flagDescription(&parse.Param{Name: "config"}, hasShortFlags),
+				"path",
+				"JSON file to read flags from if they are not given or set by environment variables",
+			})
+		}
+
+		description := function.LongDescription
+		if description == "" {
+			description = function.Description
+		}
+
+		if len(function.Aliases) != 0 {
+			aliases := "Aliases: " + strings.Join(function.Aliases, ", ")
+			if description != "" {
+				aliases = description + "\n\n" + aliases
+			}
+			description = aliases
+		}
+
+		usage := joinNonEmpty(function.UIName, strings.Join(flags, " "), strings.Join(args, " "))
+		o[function.UIName] = helpTextString("%s", description, usage, argsSection, flagsSection, envSection)
+	}
+
+	// make overall help text, and help text for every group of commands
+	usage := "<command> [<flags>] [<args>]"
+	if hasConfig {
+		usage = "[--config <path>] " + usage
+	}
+	o[""] = helpTextString("%s", "", usage, commandsSection(functions, nil))
+	for _, group := range commandGroups(functions) {
+		o[group] = helpTextString("%s", "", group+" <command> [<flags>] [<args>]", commandsSection(functions, strings.Fields(group)))
+	}
+
+	return o
+}
+
+// commandsSection returns a table of the commands and groups of commands that are directly inside the group with the
+// given path.
+func commandsSection(functions []*parse.Function, group []string) *helpSection {
+	section := &helpSection{title: "Available commands"}
+	seen := make(map[string]bool)
+
+	for _, finfo := range functions {
+		path := finfo.Path()
+		if len(path) <= len(group) || !strings.EqualFold(strings.Join(path[:len(group)], " "), strings.Join(group, " ")) {
+			continue
+		}
+
+		name := path[len(group)]
+		if len(path) > len(group)+1 {
+			// nested commands are shown once, as their group
+			if !seen[strings.ToLower(name)] {
+				section.rows = append(section.rows, []string{name + " ...", ""})
+			}
+			seen[strings.ToLower(name)] = true
+			continue
+		}
+
+		if len(finfo.Aliases) != 0 {
+			name += ", " + strings.Join(finfo.Aliases, ", ")
+		}
+		section.rows = append(section.rows, []string{name, finfo.Description})
+	}
+
+	return section
+}
+
+// flagDescription returns the names of the flag for arg as shown in help text. If alignShort is set, flags without a
+// short name are indented to line up with those that do.
+func flagDescription(arg *parse.Param, alignShort bool) string {
+	if arg.Short != "" {
+		return "-" + arg.Short + ", --" + arg.Name
+	}
+	if alignShort {
+		return "    --" + arg.Name
+	}
+	return "--" + arg.Name
+}
+
+// typeDescription returns the type of arg as shown in help text.
+func typeDescription(arg *parse.Param) string {
+	if arg.IsSlice {
+		return arg.Type + "..."
+	}
+	return arg.Type
+}
+
+// helpTextString returns help text that is used as a format string, with execName as a placeholder for the name of
+// the executable. All other values have any formatting verbs escaped.
+func helpTextString(execName, description, usage string, sections ...*helpSection) string {
+	escape := strings.NewReplacer("%", "%%").Replace
+	sb := new(strings.Builder)
+
+	if description != "" {
+		sb.WriteString(escape(description) + "\n\n")
+	}
+
+	sb.WriteString("Usage: " + execName + " " + escape(usage))
+
+	for _, section := range sections {
+		if len(section.rows) == 0 {
+			continue
+		}
+
+		sb.WriteString("\n\n" + escape(section.title) + ":")
+
+		table := new(strings.Builder)
+		tw := tabwriter.NewWriter(table, 0, 0, 2, ' ', 0)
+		for _, row := range section.rows {
+			fmt.Fprintf(tw, "    %s\n", strings.Join(row, "\t"))
+		}
+		_ = tw.Flush()
+
+		for _, line := range strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n") {
+			sb.WriteString("\n" + escape(strings.TrimRight(line, " ")))
+		}
+	}
+
+	return sb.String()
+}
+
+// joinNonEmpty joins any non-empty strings in x with spaces.
+func joinNonEmpty(x ...string) string {
+	var nonEmpty []string
+	for _, y := range x {
+		if y != "" {
+			nonEmpty = append(nonEmpty, y)
+		}
+	}
+	return strings.Join(nonEmpty, " ")
+}
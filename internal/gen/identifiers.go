@@ -0,0 +1,74 @@
+package gen
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// reservedIdentifiers are identifiers that are declared or referenced by the generated runner itself and hence cannot
+// be used for variables generated for function arguments.
+var reservedIdentifiers = []string{
+	// package level declarations
+	"intSize", "funcNames", "funcHelps", "cligenCommandGroups", "cligenValueFlags", "execName", "cligenFlagGiven", "cligenConfigFlags", "cligenLoadConfig", "runtimeError", "preparationError", "Start", "cligenFindCommand", "run",
+	// local variables in run
+	"input", "runFunc", "fname", "ok", "parsedFlags", "parsedArgs", "err", "x", "parsedConfig", "configPath", "configPaths", "split",
+	// imported packages
+	"errors", "strings", "parsecli", "bits", "strconv", "fmt", "os", "json", "regexp",
+}
+
+// identifiers allocates unique variable names for a single run of the generator.
+type identifiers struct {
+	used map[string]struct{}
+}
+
+func newIdentifiers(reserved ...string) *identifiers {
+	ids := &identifiers{
+		used: make(map[string]struct{}),
+	}
+	for _, x := range reservedIdentifiers {
+		ids.used[x] = struct{}{}
+	}
+	for _, x := range reserved {
+		ids.used[x] = struct{}{}
+	}
+	return ids
+}
+
+// next returns an identifier made from the given parts, for example `next("arg", "name")` returns `argName`. Characters
+// that can't be used in identifiers are dropped, starting a new word, so `next("arg", "dry-run")` returns `argDryRun`.
+// If that identifier has already been allocated, a numeric suffix is added to make it unique.
+func (ids *identifiers) next(parts ...string) string {
+	var base string
+	for i, part := range parts {
+		words := strings.FieldsFunc(part, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+		})
+		for j, word := range words {
+			if i != 0 || j != 0 {
+				word = upperFirst(word)
+			}
+			base += word
+		}
+	}
+
+	x := base
+	for i := 2; ; i += 1 {
+		if _, found := ids.used[x]; !found {
+			break
+		}
+		x = base + strconv.Itoa(i)
+	}
+
+	ids.used[x] = struct{}{}
+	return x
+}
+
+func upperFirst(x string) string {
+	r, size := utf8.DecodeRuneInString(x)
+	if r == utf8.RuneError {
+		return x
+	}
+	return string(unicode.ToUpper(r)) + x[size:]
+}
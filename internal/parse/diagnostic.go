@@ -0,0 +1,49 @@
+package parse
+
+import (
+	"fmt"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// Diagnostic is a problem with the source of a package that prevents a runner being generated for it.
+type Diagnostic struct {
+	Position token.Position
+	Message  string
+}
+
+func (d *Diagnostic) Error() string {
+	return d.Position.String() + ": " + d.Message
+}
+
+// Diagnostics is every problem found while parsing a package, ordered by position.
+type Diagnostics []*Diagnostic
+
+func (d Diagnostics) Error() string {
+	var lines []string
+	for _, x := range d {
+		lines = append(lines, x.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (d *Diagnostics) add(position token.Position, format string, args ...interface{}) {
+	*d = append(*d, &Diagnostic{
+		Position: position,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (d Diagnostics) sort() {
+	sort.SliceStable(d, func(i, j int) bool {
+		a, b := d[i].Position, d[j].Position
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
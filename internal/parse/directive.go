@@ -4,8 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"go/ast"
+	"go/token"
+	"math"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 const DirectiveStart = "cligen"
@@ -16,11 +20,23 @@ var (
 	errorNoDirective = errors.New("no directive found")
 )
 
-func getDirectives(commentGroup *ast.CommentGroup) ([]string, error) {
-	var matches []string
+// Directive is a single `//cligen:` comment.
+type Directive struct {
+	// Text is the content of the directive, without the leading `//cligen:`.
+	Text     string
+	Position token.Position
+}
+
+func getDirectives(commentGroup *ast.CommentGroup, fset *token.FileSet) ([]*Directive, error) {
+	var matches []*Directive
 	for _, comment := range commentGroup.List {
 		if directiveRegexp.MatchString(comment.Text) {
-			matches = append(matches, comment.Text)
+			x := strings.TrimPrefix(comment.Text, "//")
+			x = strings.TrimPrefix(x, DirectiveStart+":")
+			matches = append(matches, &Directive{
+				Text:     x,
+				Position: fset.Position(comment.Pos()),
+			})
 		}
 	}
 
@@ -28,52 +44,549 @@ func getDirectives(commentGroup *ast.CommentGroup) ([]string, error) {
 		return nil, errorNoDirective
 	}
 
-	for i, directive := range matches {
-		x := strings.TrimPrefix(directive, "//")
-		x = strings.TrimPrefix(x, DirectiveStart + ":")
-		matches[i] = x
-	}
-
 	return matches, nil
 }
 
-func applyDirectives(function *Function) error {
-	fmt.Println("---")
+// directiveSpec describes a directive that can be applied to a function.
+type directiveSpec struct {
+	// usage is shown in diagnostics when the directive is given the wrong number of arguments.
+	usage string
+	// minArgs and maxArgs are the number of arguments the directive accepts. A maxArgs of -1 means there is no limit.
+	minArgs, maxArgs int
+	apply            func(function *Function, args []string) error
+}
+
+// directiveSpecs is every directive that can be used, keyed by opcode.
+var directiveSpecs = map[string]*directiveSpec{
+	"cmd": {
+		usage:   "cmd <name>...",
+		minArgs: 1,
+		maxArgs: -1,
+		apply: func(function *Function, args []string) error {
+			for _, arg := range args {
+				if err := checkName("command name", arg); err != nil {
+					return err
+				}
+			}
+			function.UIName = strings.Join(args, " ")
+			return nil
+		},
+	},
+	"alias": {
+		usage:   "alias <name>...",
+		minArgs: 1,
+		maxArgs: -1,
+		apply: func(function *Function, args []string) error {
+			for _, arg := range args {
+				if err := checkName("alias", arg); err != nil {
+					return err
+				}
+			}
+			function.Aliases = append(function.Aliases, args...)
+			return nil
+		},
+	},
+	"rename": {
+		usage:   "rename <argument> <new name>",
+		minArgs: 2,
+		maxArgs: 2,
+		apply: func(function *Function, args []string) error {
+			param, err := function.directiveArgument(args[0])
+			if err != nil {
+				return err
+			}
+			if err := checkName("argument name", args[1]); err != nil {
+				return err
+			}
+			if existing := function.findArgument(args[1]); existing != nil && existing != param {
+				return fmt.Errorf("cannot rename argument %s to %s: an argument with that name already exists", param.Name, args[1])
+			}
+			param.Name = args[1]
+			return nil
+		},
+	},
+	"layout": {
+		usage:   "layout <argument> <time layout>",
+		minArgs: 2,
+		maxArgs: -1,
+		apply: func(function *Function, args []string) error {
+			param, err := function.directiveArgument(args[0])
+			if err != nil {
+				return err
+			}
+			if param.TypePackage != "time" || param.Type != "time.Time" {
+				return fmt.Errorf("argument %s is of type %s, but layouts can only be used with time.Time", param.Name, param.Type)
+			}
+			param.Layout = strings.Join(args[1:], " ")
+			return nil
+		},
+	},
+	"param": {
+		usage:   "param <argument> <help text>",
+		minArgs: 2,
+		maxArgs: -1,
+		apply: func(function *Function, args []string) error {
+			param, err := function.directiveArgument(args[0])
+			if err != nil {
+				return err
+			}
+			param.Description = strings.Join(args[1:], " ")
+			return nil
+		},
+	},
+	"default": {
+		usage:   "default <argument> <value>",
+		minArgs: 2,
+		maxArgs: 2,
+		apply: func(function *Function, args []string) error {
+			param, err := function.directiveArgument(args[0])
+			if err != nil {
+				return err
+			}
+			// the value is checked once all directives have been applied, since it might depend on the layout
+			param.Default = args[1]
+			param.HasDefault = true
+			return nil
+		},
+	},
+	"short": {
+		usage:   "short <argument> <letter>",
+		minArgs: 2,
+		maxArgs: 2,
+		apply: func(function *Function, args []string) error {
+			param, err := function.directiveArgument(args[0])
+			if err != nil {
+				return err
+			}
+			if len(args[1]) != 1 || !unicode.IsLetter(rune(args[1][0])) {
+				return fmt.Errorf("short flag must be a single letter, not %#v", args[1])
+			}
+			param.Short = args[1]
+			return nil
+		},
+	},
+	"choices": {
+		usage:   "choices <argument> [<value>...]",
+		minArgs: 1,
+		maxArgs: -1,
+		apply: func(function *Function, args []string) error {
+			param, err := function.directiveArgument(args[0])
+			if err != nil {
+				return err
+			}
+			if param.Kind != "string" || param.IsTextUnmarshaler {
+				return fmt.Errorf("argument %s is of type %s, but choices can only be used with strings", param.Name, param.Type)
+			}
+			if len(args) == 1 {
+				// use the constants declared with the type of the argument
+				if len(param.constants) == 0 {
+					return fmt.Errorf("argument %s is of type %s, which has no constants declared in this package to use as choices", param.Name, param.Type)
+				}
+				param.Choices = param.constants
+				return nil
+			}
+			param.Choices = args[1:]
+			return nil
+		},
+	},
+	"min": {
+		usage:   "min <argument> <value>",
+		minArgs: 2,
+		maxArgs: 2,
+		apply: func(function *Function, args []string) error {
+			param, err := numberDirectiveArgument(function, args)
+			if err != nil {
+				return err
+			}
+			param.Min = args[1]
+			return nil
+		},
+	},
+	"max": {
+		usage:   "max <argument> <value>",
+		minArgs: 2,
+		maxArgs: 2,
+		apply: func(function *Function, args []string) error {
+			param, err := numberDirectiveArgument(function, args)
+			if err != nil {
+				return err
+			}
+			param.Max = args[1]
+			return nil
+		},
+	},
+	"pattern": {
+		usage:   "pattern <argument> <regular expression>",
+		minArgs: 2,
+		maxArgs: 2,
+		apply: func(function *Function, args []string) error {
+			param, err := function.directiveArgument(args[0])
+			if err != nil {
+				return err
+			}
+			if param.Kind != "string" || param.IsTextUnmarshaler {
+				return fmt.Errorf("argument %s is of type %s, but patterns can only be used with strings", param.Name, param.Type)
+			}
+			if _, err := regexp.Compile(args[1]); err != nil {
+				return err
+			}
+			param.Pattern = args[1]
+			return nil
+		},
+	},
+	"required": {
+		usage:   "required <argument>...",
+		minArgs: 1,
+		maxArgs: -1,
+		apply: func(function *Function, args []string) error {
+			params, err := function.directiveArguments(args)
+			if err != nil {
+				return err
+			}
+			for _, param := range params {
+				param.Required = true
+			}
+			return nil
+		},
+	},
+	"exclusive": {
+		usage:   "exclusive <argument> <argument>...",
+		minArgs: 2,
+		maxArgs: -1,
+		apply: func(function *Function, args []string) error {
+			params, err := function.directiveArguments(args)
+			if err != nil {
+				return err
+			}
+			function.ExclusiveFlags = append(function.ExclusiveFlags, params)
+			return nil
+		},
+	},
+	"atleastone": {
+		usage:   "atleastone <argument> <argument>...",
+		minArgs: 2,
+		maxArgs: -1,
+		apply: func(function *Function, args []string) error {
+			params, err := function.directiveArguments(args)
+			if err != nil {
+				return err
+			}
+			function.AtLeastOneFlags = append(function.AtLeastOneFlags, params)
+			return nil
+		},
+	},
+	"env": {
+		usage:   "env <argument> <variable>",
+		minArgs: 2,
+		maxArgs: 2,
+		apply: func(function *Function, args []string) error {
+			param, err := function.directiveArgument(args[0])
+			if err != nil {
+				return err
+			}
+			if err := checkName("environment variable name", args[1]); err != nil {
+				return err
+			}
+			param.Env = args[1]
+			return nil
+		},
+	},
+	"description": {
+		usage:   "description <text>",
+		minArgs: 1,
+		maxArgs: -1,
+		apply: func(function *Function, args []string) error {
+			function.Description = strings.Join(args, " ")
+			function.LongDescription = function.Description
+			return nil
+		},
+	},
+}
+
+// programDirectiveSpec describes a directive that can be applied to a whole program from the doc comment of a package.
+type programDirectiveSpec struct {
+	usage            string
+	minArgs, maxArgs int
+	apply            func(program *Program, args []string) error
+}
+
+// programDirectiveSpecs is every directive that can be used in package doc comments, keyed by opcode.
+var programDirectiveSpecs = map[string]*programDirectiveSpec{
+	"config": {
+		usage:   "config",
+		minArgs: 0,
+		maxArgs: 0,
+		apply: func(program *Program, args []string) error {
+			program.Config = true
+			return nil
+		},
+	},
+	"envprefix": {
+		usage:   "envprefix <prefix>",
+		minArgs: 1,
+		maxArgs: 1,
+		apply: func(program *Program, args []string) error {
+			if program.EnvPrefix != "" {
+				return fmt.Errorf("environment variable prefix is already set to %s", program.EnvPrefix)
+			}
+			if err := checkName("environment variable prefix", args[0]); err != nil {
+				return err
+			}
+			program.EnvPrefix = args[0]
+			return nil
+		},
+	},
+}
+
+func applyProgramDirectives(program *Program, files []*ast.File, fset *token.FileSet, diags *Diagnostics) {
+	for _, file := range files {
+		if file.Doc == nil {
+			continue
+		}
+
+		directives, err := getDirectives(file.Doc, fset)
+		if err != nil {
+			continue
+		}
+
+		for _, directive := range directives {
+			split, err := splitDirective(directive.Text)
+			if err != nil {
+				diags.add(directive.Position, "%s", err.Error())
+				continue
+			}
+			if len(split) == 0 {
+				continue
+			}
+			opcode, args := split[0], split[1:]
+
+			spec, found := programDirectiveSpecs[opcode]
+			if !found {
+				if _, found := directiveSpecs[opcode]; found {
+					diags.add(directive.Position, "%s directive can only be used on functions", opcode)
+				} else if suggestion := suggestOpcode(opcode); suggestion != "" {
+					diags.add(directive.Position, "unknown package directive %#v, did you mean %#v?", opcode, suggestion)
+				} else {
+					diags.add(directive.Position, "unknown package directive %#v", opcode)
+				}
+				continue
+			}
+
+			if len(args) < spec.minArgs || (spec.maxArgs != -1 && len(args) > spec.maxArgs) {
+				diags.add(directive.Position, "wrong number of arguments to %s directive, usage: %s:%s", opcode, DirectiveStart, spec.usage)
+				continue
+			}
+
+			if err := spec.apply(program, args); err != nil {
+				diags.add(directive.Position, "%s directive: %s", opcode, err.Error())
+			}
+		}
+	}
+}
+
+func applyDirectives(function *Function, diags *Diagnostics) {
 	for _, directive := range function.Directives {
 
-		split := strings.Split(directive, " ")
+		split, err := splitDirective(directive.Text)
+		if err != nil {
+			diags.add(directive.Position, "%s", err.Error())
+			continue
+		}
 		if len(split) == 0 {
 			continue
 		}
-		opcode, split := split[0], split[1:]
-		fmt.Println(opcode, split)
+		opcode, args := split[0], split[1:]
 
-		switch opcode {
-		case "cmd":
-			if len(split) >= 1 {
-				function.UIName = split[0]
-			}
-		case "rename":
-			if len(split) < 2 {
-				return errors.New("rename directive missing arguments for old and new argument names")
+		spec, found := directiveSpecs[opcode]
+		if !found {
+			if suggestion := suggestOpcode(opcode); suggestion != "" {
+				diags.add(directive.Position, "unknown directive %#v, did you mean %#v?", opcode, suggestion)
+			} else {
+				diags.add(directive.Position, "unknown directive %#v", opcode)
 			}
+			continue
+		}
+
+		if len(args) < spec.minArgs || (spec.maxArgs != -1 && len(args) > spec.maxArgs) {
+			diags.add(directive.Position, "wrong number of arguments to %s directive, usage: %s:%s", opcode, DirectiveStart, spec.usage)
+			continue
+		}
 
-			searchFor := split[0]
-			renameTo := split[1]
+		if err := spec.apply(function, args); err != nil {
+			diags.add(directive.Position, "%s directive: %s", opcode, err.Error())
+		}
+	}
+}
+
+// splitDirective splits the text of a directive into whitespace separated tokens. Tokens can be quoted using Go string
+// literal syntax (double quotes or backticks) in order to include whitespace.
+func splitDirective(text string) ([]string, error) {
+	var tokens []string
 
-			for _, sig := range function.Signature.Argument {
-				if strings.EqualFold(searchFor, sig.Name) {
-					sig.Name = renameTo
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case unicode.IsSpace(rune(c)):
+			i += 1
+
+		case c == '"' || c == '`':
+			end := i + 1
+			for ; end < len(text); end += 1 {
+				if c == '"' && text[end] == '\\' {
+					// skip whatever is escaped, so an escaped quote doesn't end the string
+					end += 1
+					continue
+				}
+				if text[end] == c {
 					break
 				}
 			}
-		case "description":
-			if len(split) == 0 {
-				return errors.New("description directive missing description")
+			if end >= len(text) {
+				return nil, fmt.Errorf("unterminated string literal %s", text[i:])
 			}
-			function.Description = strings.Join(split, " ")
+
+			token, err := strconv.Unquote(text[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string literal %s", text[i:end+1])
+			}
+			tokens = append(tokens, token)
+
+			i = end + 1
+			if i < len(text) && !unicode.IsSpace(rune(text[i])) {
+				return nil, fmt.Errorf("string literal %s must be followed by whitespace", text[:i])
+			}
+
+		default:
+			end := strings.IndexFunc(text[i:], unicode.IsSpace)
+			if end == -1 {
+				end = len(text)
+			} else {
+				end += i
+			}
+			tokens = append(tokens, text[i:end])
+			i = end
 		}
+	}
+
+	return tokens, nil
+}
 
+// checkName returns an error if name can't be used as the name of a command, flag or environment variable. Names can
+// only contain letters, digits, `-` and `_`, and can't start with `-`.
+func checkName(kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("%s cannot be empty", kind)
+	}
+	if name[0] == '-' {
+		return fmt.Errorf("%s %#v cannot start with -", kind, name)
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return fmt.Errorf("%s %#v can only contain letters, digits, - and _", kind, name)
+		}
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// directiveArgument returns the argument of function with the given name, or an error suitable for reporting from a
+// directive if there is no such argument.
+func (function *Function) directiveArgument(name string) (*Param, error) {
+	param := function.findArgument(name)
+	if param == nil {
+		return nil, fmt.Errorf("function %s has no argument named %#v", function.Name, name)
+	}
+	return param, nil
+}
+
+// directiveArguments returns the arguments of function with the given names, or an error suitable for reporting from
+// a directive if any of them don't exist or the same argument is named more than once.
+func (function *Function) directiveArguments(names []string) ([]*Param, error) {
+	var params []*Param
+	for _, name := range names {
+		param, err := function.directiveArgument(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, existing := range params {
+			if existing == param {
+				return nil, fmt.Errorf("argument %s is given more than once", param.Name)
+			}
+		}
+		params = append(params, param)
+	}
+	return params, nil
+}
+
+// numberDirectiveArgument returns the argument of function named by args[0], checking that it is a number and that
+// args[1] is a valid value for it.
+func numberDirectiveArgument(function *Function, args []string) (*Param, error) {
+	param, err := function.directiveArgument(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !isNumber(param) {
+		return nil, fmt.Errorf("argument %s is of type %s, but bounds can only be used with numbers", param.Name, param.Type)
+	}
+	if err := checkValue(param, args[1]); err != nil {
+		return nil, fmt.Errorf("invalid value for argument %s: %s", param.Name, err.Error())
+	}
+	if x, err := strconv.ParseFloat(args[1], 64); err == nil && (math.IsInf(x, 0) || math.IsNaN(x)) {
+		return nil, fmt.Errorf("invalid value for argument %s: must be a finite number", param.Name)
+	}
+	return param, nil
+}
+
+// suggestOpcode returns the known opcode that is closest to opcode, or an empty string if there is none that is
+// close enough to be a likely misspelling.
+func suggestOpcode(opcode string) string {
+	var (
+		best         string
+		bestDistance int
+	)
+
+	var candidates []string
+	for known := range directiveSpecs {
+		candidates = append(candidates, known)
+	}
+	for known := range programDirectiveSpecs {
+		candidates = append(candidates, known)
+	}
+
+	for _, known := range candidates {
+		distance := levenshtein(strings.ToLower(opcode), known)
+		if best == "" || distance < bestDistance || (distance == bestDistance && known < best) {
+			best = known
+			bestDistance = distance
+		}
+	}
+
+	// allow roughly one mistake for every three characters
+	if bestDistance > 1+len(best)/3 {
+		return ""
+	}
+	return best
+}
+
+// levenshtein returns the number of single character insertions, deletions and substitutions needed to turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i += 1 {
+		current[0] = i
+		for j := 1; j <= len(rb); j += 1 {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
@@ -0,0 +1,56 @@
+package parse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_suggestOpcode(t *testing.T) {
+	tests := []struct {
+		opcode string
+		want   string
+	}{
+		{opcode: "desription", want: "description"},
+		{opcode: "CMD", want: "cmd"},
+		{opcode: "renam", want: "rename"},
+		{opcode: "envprefx", want: "envprefix"},
+		{opcode: "confg", want: "config"},
+		{opcode: "banana", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.opcode, func(t *testing.T) {
+			if got := suggestOpcode(tt.opcode); got != tt.want {
+				t.Errorf("suggestOpcode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_splitDirective(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    []string
+		wantErr bool
+	}{
+		{text: "cmd  hello", want: []string{"cmd", "hello"}},
+		{text: `description "hello   world"`, want: []string{"description", "hello   world"}},
+		{text: "layout at `2006-01-02  15:04`", want: []string{"layout", "at", "2006-01-02  15:04"}},
+		{text: `description "say \"hi\"\t"`, want: []string{"description", "say \"hi\"\t"}},
+		{text: `description ""`, want: []string{"description", ""}},
+		{text: `description "hello`, wantErr: true},
+		{text: `description "hello"world`, wantErr: true},
+		{text: `description "\q"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := splitDirective(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("splitDirective() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitDirective() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
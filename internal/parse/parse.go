@@ -2,61 +2,149 @@ package parse
 
 import (
 	"errors"
-	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
-	"io/fs"
+	"golang.org/x/tools/go/packages"
+	"path/filepath"
+	"sort"
 	"strings"
+	"unicode"
 )
 
 type Program struct {
 	PackageName string
-	Functions   map[string]*Function
+	// EnvPrefix is used to derive the names of environment variables for flags that don't have one set explicitly.
+	EnvPrefix string
+	// Config is set if the runner should accept a --config flag giving a JSON file to read flag values from.
+	Config bool
+	// Functions is in the order that functions are declared in source, taking files in lexical order.
+	Functions []*Function
 }
 
 func Directory(dir string) (*Program, error) {
-	fset := token.NewFileSet()
-	packages, err := parser.ParseDir(fset, dir, func(info fs.FileInfo) bool {
-		name := info.Name()
-		return strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, ".cligen.go")
-	}, parser.ParseComments)
+	absDir, err := filepath.Abs(dir)
 	if err != nil {
 		return nil, err
 	}
-	if len(packages) != 1 {
-		return nil, errors.New("input directory must only have source files with at most a single package in it") // TODO: This error is phrased weird.
-	}
 
-	var pkg *ast.Package
-	for _, x := range packages {
-		pkg = x
-		break
+	fset := token.NewFileSet()
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedImports | packages.NeedDeps |
+			packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:  dir,
+		Fset: fset,
+		ParseFile: func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+			mode := parser.AllErrors | parser.ParseComments
+			if filepath.Dir(filename) == absDir && strings.HasSuffix(filename, ".cligen.go") {
+				// existing runners are treated as if they are empty, since they're going to be replaced
+				mode = parser.PackageClauseOnly
+			}
+			return parser.ParseFile(fset, filename, src, mode)
+		},
 	}
 
-	functions, err := getFunctionsFromPackage(pkg)
+	pkgs, err := packages.Load(cfg, ".")
 	if err != nil {
 		return nil, err
 	}
+	if len(pkgs) != 1 {
+		return nil, errors.New("input directory must contain exactly one package")
+	}
+	pkg := pkgs[0]
 
-	return &Program{
+	// type errors are allowed, since code in the package might refer to things declared in the runner that's about
+	// to be generated - any that affect commands show up as invalid types later on
+	for _, e := range pkg.Errors {
+		if e.Kind != packages.TypeError {
+			return nil, e
+		}
+	}
+
+	ti := &typeInfo{
+		fset: fset,
+		pkg:  pkg.Types,
+		info: pkg.TypesInfo,
+	}
+
+	files := sortedFiles(fset, pkg.Syntax)
+	program := &Program{
 		PackageName: pkg.Name,
-		Functions:   functions,
-	}, nil
+	}
+
+	var diags Diagnostics
+	applyProgramDirectives(program, files, fset, &diags)
+	program.Functions = getFunctionsFromPackage(files, ti, &diags)
+
+	if program.Config {
+		for _, function := range program.Functions {
+			if arg := function.findArgument("config"); arg != nil && arg.IsFlag() {
+				diags.add(arg.position, "flag --%s clashes with the --config flag", arg.Name)
+			}
+		}
+	}
+
+	if len(diags) != 0 {
+		diags.sort()
+		return nil, diags
+	}
+
+	if program.EnvPrefix != "" {
+		for _, function := range program.Functions {
+			for _, arg := range function.Signature.Argument {
+				if arg.IsFlag() && arg.Env == "" {
+					arg.Env = envName(program.EnvPrefix, arg.Name)
+				}
+			}
+		}
+	}
+
+	return program, nil
+}
+
+// sortedFiles returns files in lexical order of their file names, so that output is stable.
+func sortedFiles(fset *token.FileSet, files []*ast.File) []*ast.File {
+	sorted := make([]*ast.File, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool {
+		return fset.File(sorted[i].Pos()).Name() < fset.File(sorted[j].Pos()).Name()
+	})
+	return sorted
 }
 
 type Function struct {
-	Name        string
-	UIName      string
-	Directives  []string
-	Signature   *Signature
+	Name string
+	// UIName is the name that the function is run with. Nested commands have a space separated path, for example
+	// `db migrate`.
+	UIName string
+	// Aliases are alternative names for the last element of UIName.
+	Aliases    []string
+	Directives []*Directive
+	Signature  *Signature
+	// Description is a short, one line description of the function.
 	Description string
+	// LongDescription is the full text of the doc comment of the function, excluding any directives.
+	LongDescription string
+	// ExclusiveFlags are groups of flags where no more than one flag from each group can be given.
+	ExclusiveFlags [][]*Param
+	// AtLeastOneFlags are groups of flags where at least one flag from each group must be given.
+	AtLeastOneFlags [][]*Param
+
+	position token.Position
+}
+
+// Path returns the names of the groups that the command is nested in, followed by the name of the command itself.
+func (function *Function) Path() []string {
+	return strings.Fields(function.UIName)
 }
 
-func getFunctionsFromPackage(pkg *ast.Package) (map[string]*Function, error) {
-	functions := make(map[string]*Function)
+func getFunctionsFromPackage(files []*ast.File, ti *typeInfo, diags *Diagnostics) []*Function {
+	var functions []*Function
+	uiNames := make(map[string]*Function)
+	// groups are the lowercased paths of every command that has other commands nested inside it
+	groups := make(map[string]*Function)
 
-	for _, file := range pkg.Files {
+	for _, file := range files {
 		for _, declaration := range file.Decls {
 
 			if funcDecl, ok := declaration.(*ast.FuncDecl); ok {
@@ -71,33 +159,139 @@ func getFunctionsFromPackage(pkg *ast.Package) (map[string]*Function, error) {
 					continue
 				}
 
-				function := new(Function)
-				function.Signature = signatureFromDeclaration(funcDecl)
+				position := ti.fset.Position(funcDecl.Name.Pos())
 
-				directives, err := getDirectives(funcDecl.Doc)
+				directives, err := getDirectives(funcDecl.Doc, ti.fset)
 				if err != nil {
 					if errors.Is(err, errorNoDirective) {
 						continue
 					} else {
-						return nil, fmt.Errorf("%s:%s: %s", pkg.Name, funcDecl.Name.String(), err.Error())
+						diags.add(position, "%s", err.Error())
+						continue
 					}
 				}
 
+				function := new(Function)
+				function.Signature = signatureFromDeclaration(funcDecl, ti, diags)
 				function.Directives = directives
 				function.Name = funcDecl.Name.String()
+				function.position = position
 				function.UIName = function.Name
+				function.LongDescription = docText(funcDecl.Doc)
+				function.Description = firstSentence(function.LongDescription)
+
+				applyDirectives(function, diags)
+				validateSignature(function.Signature, diags)
+				validateFlagConstraints(function, diags)
+
+				path := function.Path()
+				if len(path) == 0 {
+					diags.add(position, "command name cannot be empty")
+					continue
+				}
+				if strings.EqualFold(path[0], "help") {
+					diags.add(position, "disallowed command name %#v: help is a reserved name", function.UIName)
+				}
 
-				if strings.ToLower(function.UIName) == "help" {
-					return nil, errors.New("disallowed function name \"help\": help is a reserved name")
+				parent := strings.Join(path[:len(path)-1], " ")
+				for _, name := range append([]string{path[len(path)-1]}, function.Aliases...) {
+					fullName := strings.TrimSpace(parent + " " + name)
+					lowerName := strings.ToLower(fullName)
+					if lowerName == "help" {
+						if name != path[len(path)-1] {
+							diags.add(position, "disallowed alias %#v: help is a reserved name", name)
+						}
+						continue
+					}
+
+					if existing, found := uiNames[lowerName]; found {
+						diags.add(position, "duplicate command name %#v, already used by function %s", fullName, existing.Name)
+					} else {
+						uiNames[lowerName] = function
+					}
 				}
 
-				applyDirectives(function)
+				for i := 1; i < len(path); i += 1 {
+					group := strings.ToLower(strings.Join(path[:i], " "))
+					if _, found := groups[group]; !found {
+						groups[group] = function
+					}
+				}
 
-				functions[function.UIName] = function
+				functions = append(functions, function)
 			}
 
 		}
 	}
 
-	return functions, nil
+	var names []string
+	for name := range uiNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		function := uiNames[name]
+		if group, found := groups[name]; found {
+			diags.add(function.position, "command %#v clashes with a group of commands of the same name used by function %s", name, group.Name)
+		}
+	}
+
+	return functions
+}
+
+// findArgument returns the argument of function with the given name, ignoring case, or nil if there is no such
+// argument.
+func (function *Function) findArgument(name string) *Param {
+	for _, arg := range function.Signature.Argument {
+		if strings.EqualFold(name, arg.Name) {
+			return arg
+		}
+	}
+	return nil
+}
+
+// docText returns the text of a doc comment without any directives in it.
+func docText(doc *ast.CommentGroup) string {
+	withoutDirectives := new(ast.CommentGroup)
+	for _, comment := range doc.List {
+		if !directiveRegexp.MatchString(comment.Text) {
+			withoutDirectives.List = append(withoutDirectives.List, comment)
+		}
+	}
+	return strings.TrimSpace(withoutDirectives.Text())
+}
+
+// envName returns the name of the environment variable for the flag with the given name, for example `MYTOOL_DRY_RUN`
+// for the prefix `MYTOOL` and flag `dryRun`.
+func envName(prefix, name string) string {
+	sb := new(strings.Builder)
+	sb.WriteString(strings.ToUpper(prefix) + "_")
+
+	var previous rune
+	for _, r := range name {
+		switch {
+		case unicode.IsUpper(r) && (unicode.IsLower(previous) || unicode.IsDigit(previous)):
+			sb.WriteRune('_')
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			r = '_'
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+		previous = r
+	}
+
+	return sb.String()
+}
+
+// firstSentence returns the first sentence of the first paragraph of text, on a single line.
+func firstSentence(text string) string {
+	if i := strings.Index(text, "\n\n"); i != -1 {
+		text = text[:i]
+	}
+	text = strings.Join(strings.Fields(text), " ")
+
+	if i := strings.Index(text, ". "); i != -1 {
+		text = text[:i+1]
+	}
+	return text
 }
@@ -0,0 +1,239 @@
+package parse
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_firstSentence(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "Copy copies files. It is fast.", want: "Copy copies files."},
+		{text: "Copy copies\nfiles from one place to another", want: "Copy copies files from one place to another"},
+		{text: "Copy copies files\n\nSecond paragraph. Another sentence.", want: "Copy copies files"},
+		{text: "Uses v1.2 of the format", want: "Uses v1.2 of the format"},
+		{text: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := firstSentence(tt.text); got != tt.want {
+				t.Errorf("firstSentence() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		param   *Param
+		value   string
+		wantErr bool
+	}{
+		{name: "int8", param: &Param{Type: "int8", Kind: "int8"}, value: "-128"},
+		{name: "int8 overflow", param: &Param{Type: "int8", Kind: "int8"}, value: "128", wantErr: true},
+		{name: "hex uint16", param: &Param{Type: "Port", Kind: "uint16"}, value: "0xFFFF"},
+		{name: "bool", param: &Param{Type: "bool", Kind: "bool"}, value: "yes", wantErr: true},
+		{name: "duration", param: &Param{Type: "time.Duration", TypePackage: "time", Kind: "int64"}, value: "30s"},
+		{name: "time with layout", param: &Param{Type: "time.Time", TypePackage: "time", Layout: "2006-01-02"}, value: "2021-01-02"},
+		{name: "time without layout", param: &Param{Type: "time.Time", TypePackage: "time"}, value: "2021-01-02", wantErr: true},
+		{name: "text unmarshaler", param: &Param{Type: "Mode", IsTextUnmarshaler: true}, value: "anything"},
+		{name: "choice", param: &Param{Type: "Format", Kind: "string", Choices: []string{"json", "yaml"}}, value: "yaml"},
+		{name: "not a choice", param: &Param{Type: "Format", Kind: "string", Choices: []string{"json", "yaml"}}, value: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkValue(tt.param, tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("checkValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_envName(t *testing.T) {
+	tests := []struct {
+		prefix string
+		name   string
+		want   string
+	}{
+		{prefix: "MYTOOL", name: "timeout", want: "MYTOOL_TIMEOUT"},
+		{prefix: "mytool", name: "dryRun", want: "MYTOOL_DRY_RUN"},
+		{prefix: "MYTOOL", name: "parseURL", want: "MYTOOL_PARSE_URL"},
+		{prefix: "MYTOOL", name: "v2Api", want: "MYTOOL_V2_API"},
+		{prefix: "MYTOOL", name: "dry-run", want: "MYTOOL_DRY_RUN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := envName(tt.prefix, tt.name); got != tt.want {
+				t.Errorf("envName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkConstraints(t *testing.T) {
+	tests := []struct {
+		name    string
+		param   *Param
+		value   string
+		wantErr bool
+	}{
+		{name: "within bounds", param: &Param{Type: "int", Kind: "int", Min: "-5", Max: "0x10"}, value: "16"},
+		{name: "below minimum", param: &Param{Type: "int", Kind: "int", Min: "-5"}, value: "-6", wantErr: true},
+		{name: "above maximum", param: &Param{Type: "float64", Kind: "float64", Max: "1.5"}, value: "1.51", wantErr: true},
+		{name: "large uint", param: &Param{Type: "uint64", Kind: "uint64", Min: "18446744073709551614"}, value: "18446744073709551615"},
+		{name: "duration", param: &Param{Type: "time.Duration", TypePackage: "time", Kind: "int64", Max: "1m"}, value: "61s", wantErr: true},
+		{name: "pattern", param: &Param{Type: "string", Kind: "string", Pattern: "^[a-z]+$"}, value: "abc"},
+		{name: "pattern mismatch", param: &Param{Type: "string", Kind: "string", Pattern: "^[a-z]+$"}, value: "ABC", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkConstraints(tt.param, tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("checkConstraints() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_constantValues(t *testing.T) {
+	const src = `package example
+
+type Format string
+
+const (
+	FormatTable Format = "table"
+	FormatJSON  Format = "json"
+	FormatAlias        = FormatJSON
+	formatYAML  Format = "yaml"
+)
+
+const notFormat = "xml"
+
+type Path string
+
+type Level int
+
+const LevelDebug Level = 1
+
+type Mode string
+
+func (m *Mode) UnmarshalText(b []byte) error { return nil }
+
+const ModeFast Mode = "fast"
+`
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "example.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	pkg, err := (&types.Config{Importer: importer.Default()}).Check("example", fset, []*ast.File{file}, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := &typeInfo{fset: fset, pkg: pkg, info: info}
+
+	tests := []struct {
+		typeName string
+		want     []string
+	}{
+		{typeName: "Format", want: []string{"table", "json", "yaml"}},
+		{typeName: "Path", want: nil},
+		{typeName: "Level", want: nil},
+		{typeName: "Mode", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typeName, func(t *testing.T) {
+			typ := pkg.Scope().Lookup(tt.typeName).Type()
+			if got := ti.constantValues(typ); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("constantValues() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// parseSource parses src as the only file of a package in a new module, returning the error from Directory.
+func parseSource(t *testing.T, src string) error {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":      "module example.com/commands\n\ngo 1.22.0\n",
+		"commands.go": src,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := Directory(dir)
+	return err
+}
+
+func TestDirectoryDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		src  string
+		want string
+	}{
+		{
+			name: "misspelt package directive",
+			doc:  "//cligen:envprefx APP\n",
+			want: `unknown package directive "envprefx", did you mean "envprefix"?`,
+		},
+		{
+			name: "empty command name",
+			src:  "//cligen:cmd \"\"\nfunc Hello() {}",
+			want: "cmd directive: command name cannot be empty",
+		},
+		{
+			name: "command name with whitespace",
+			src:  "//cligen:cmd \"a b\"\nfunc Hello() {}",
+			want: `cmd directive: command name "a b" can only contain letters, digits, - and _`,
+		},
+		{
+			name: "rename with whitespace",
+			src:  "//cligen:rename x \"y z\"\nfunc Hello(x *int) {}",
+			want: `rename directive: argument name "y z" can only contain letters, digits, - and _`,
+		},
+		{
+			name: "empty rename",
+			src:  "//cligen:rename x \"\"\nfunc Hello(x *int) {}",
+			want: "rename directive: argument name cannot be empty",
+		},
+		{
+			name: "empty alias",
+			src:  "//cligen:alias \"\"\nfunc Hello() {}",
+			want: "alias directive: alias cannot be empty",
+		},
+		{
+			name: "alias starting with hyphen",
+			src:  "//cligen:alias -h\nfunc Hello() {}",
+			want: `alias directive: alias "-h" cannot start with -`,
+		},
+		{
+			name: "invalid environment variable",
+			src:  "//cligen:env x A=B\nfunc Hello(x *int) {}",
+			want: `env directive: environment variable name "A=B" can only contain letters, digits, - and _`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parseSource(t, tt.doc+"package commands\n\n"+tt.src+"\n")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Directory() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,16 @@ package parse
 import (
 	"fmt"
 	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"math/big"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Signature struct {
@@ -13,18 +23,67 @@ type Signature struct {
 type Param struct {
 	Name        string
 	Description string
-	Type        string
+	// Type is the name of the type of the parameter, or the type of its elements if IsSlice is set. Types from other
+	// packages are qualified with the name of that package, for example `time.Duration`.
+	Type string
+	// Kind is the name of the underlying basic type of Type, for example `string` for `type Mode string`, or an empty
+	// string if the underlying type is not a basic type.
+	Kind string
+	// TypePackage is the import path of the package that Type is declared in, if it is not a builtin type or a type
+	// declared in the package being parsed.
+	TypePackage string
 	IsPointer   bool
+	IsSlice     bool
+	// IsVariadic is set for the final parameter of a variadic function, in which case IsSlice is also set.
+	IsVariadic bool
+	// IsTextUnmarshaler is set if a pointer to Type implements encoding.TextUnmarshaler.
+	IsTextUnmarshaler bool
+	// Layout is the time layout used to parse time.Time values.
+	Layout string
+	// Short is the single letter alias for the flag of the parameter, if it has one.
+	Short string
+	// Default is the value used for the parameter if its flag is not given. It is only valid if HasDefault is set.
+	Default    string
+	HasDefault bool
+	// Env is the name of the environment variable used for the parameter if its flag is not given.
+	Env string
+	// Choices are the only values that the parameter can be given, if there are any.
+	Choices []string
+	// Min and Max are the smallest and largest values allowed for a numeric parameter, if they are not empty.
+	Min, Max string
+	// Pattern is a regular expression that values of a string parameter must match, if it is not empty.
+	Pattern string
+	// Required is set if the flag for the parameter must always be given.
+	Required bool
+
+	position token.Position
+	// constants are the values of the constants declared with the type of the parameter, used as its choices if the
+	// choices directive doesn't list any.
+	constants []string
+	// unresolved is set if the type of the parameter could not be determined, which will already have been reported.
+	unresolved bool
+}
+
+// IsFlag returns true if the parameter is given using a flag, as opposed to being a positional argument.
+func (p *Param) IsFlag() bool {
+	return p.IsPointer || p.HasDefault
+}
+
+// typeInfo is the result of type checking the package being parsed.
+type typeInfo struct {
+	fset *token.FileSet
+	pkg  *types.Package
+	info *types.Info
 }
 
-func signatureFromDeclaration(f *ast.FuncDecl) *Signature {
+func signatureFromDeclaration(f *ast.FuncDecl, ti *typeInfo, diags *Diagnostics) *Signature {
 	return &Signature{
-		Argument: unwrapFieldList(f.Type.Params),
-		Return:   unwrapFieldList(f.Type.Results),
+		Argument: unwrapFieldList(f.Type.Params, ti, diags),
+		Return:   unwrapFieldList(f.Type.Results, ti, diags),
 	}
 }
 
-func unwrapFieldList(list *ast.FieldList) []*Param {
+func unwrapFieldList(list *ast.FieldList, ti *typeInfo, diags *Diagnostics) []*Param {
 	if list == nil {
 		return nil
 	}
@@ -33,41 +92,366 @@ func unwrapFieldList(list *ast.FieldList) []*Param {
 	for _, item := range list.List {
 
 		var (
-			typeName  string
-			isPointer bool
+			typ        types.Type
+			isPointer  bool
+			isSlice    bool
+			isVariadic bool
 		)
 
 		{
-			var ident *ast.Ident
-
-			switch x := item.Type.(type) {
-			case *ast.Ident:
-				ident = x
-			case *ast.StarExpr:
-				isPointer = true
-				ident = x.X.(*ast.Ident)
-			default:
-				panic(fmt.Errorf("unknown type %T", item.Type))
+			expr := item.Type
+
+			if x, ok := expr.(*ast.Ellipsis); ok {
+				isSlice = true
+				isVariadic = true
+				expr = x.Elt
+			} else {
+				if x, ok := expr.(*ast.StarExpr); ok {
+					isPointer = true
+					expr = x.X
+				}
+
+				if x, ok := expr.(*ast.ArrayType); ok && x.Len == nil {
+					isSlice = true
+					expr = x.Elt
+				}
 			}
 
-			typeName = ident.Name
+			typ = ti.info.TypeOf(expr)
+			if typ == nil {
+				typ = types.Typ[types.Invalid]
+			}
+			if typ == types.Typ[types.Invalid] {
+				diags.add(ti.fset.Position(expr.Pos()), "unable to resolve type %s", types.ExprString(expr))
+			}
+		}
+
+		var kind string
+		if basic, ok := typ.Underlying().(*types.Basic); ok && basic.Info()&(types.IsBoolean|types.IsNumeric|types.IsString) != 0 {
+			kind = types.Typ[basic.Kind()].Name()
+		}
+
+		newParam := func(position token.Pos, name string) *Param {
+			return &Param{
+				Name:              name,
+				Type:              types.TypeString(typ, ti.qualifier),
+				Kind:              kind,
+				TypePackage:       ti.packagePath(typ),
+				IsPointer:         isPointer,
+				IsSlice:           isSlice,
+				IsVariadic:        isVariadic,
+				IsTextUnmarshaler: isTextUnmarshaler(typ),
+				constants:         ti.constantValues(typ),
+				position:          ti.fset.Position(position),
+				unresolved:        typ == types.Typ[types.Invalid],
+			}
 		}
 
 		if len(item.Names) == 0 {
 			// if there are no names associated with this type, we still need to know about it
-			params = append(params, &Param{
-				Type:      typeName,
-				IsPointer: isPointer,
-			})
+			params = append(params, newParam(item.Pos(), ""))
 		} else {
 			for _, name := range item.Names {
-				params = append(params, &Param{
-					Name:      name.Name,
-					Type:      typeName,
-					IsPointer: isPointer,
-				})
+				params = append(params, newParam(name.Pos(), name.Name))
 			}
 		}
 	}
 	return params
 }
+
+// validateSignature checks that a runner can be generated to call a function with the given signature.
+func validateSignature(sig *Signature, diags *Diagnostics) {
+	var trailingSlice *Param
+	for _, arg := range sig.Argument {
+		if !arg.unresolved && !isSupportedType(arg) {
+			diags.add(arg.position, "unsupported argument type %s", arg.Type)
+		}
+
+		if arg.HasDefault {
+			if arg.IsSlice {
+				diags.add(arg.position, "slice argument %s cannot have a default value", arg.Name)
+			} else if err := checkValue(arg, arg.Default); err != nil {
+				diags.add(arg.position, "invalid default value for argument %s: %s", arg.Name, err.Error())
+			} else if err := checkConstraints(arg, arg.Default); err != nil {
+				diags.add(arg.position, "invalid default value for argument %s: %s", arg.Name, err.Error())
+			}
+		}
+
+		if arg.Min != "" && arg.Max != "" && compareNumbers(arg, arg.Min, arg.Max) > 0 {
+			diags.add(arg.position, "minimum value %s for argument %s is greater than the maximum value %s", arg.Min, arg.Name, arg.Max)
+		}
+
+		if arg.IsFlag() {
+			continue
+		}
+
+		if trailingSlice != nil {
+			diags.add(trailingSlice.position, "slice argument %s must be the last positional argument", trailingSlice.Name)
+			trailingSlice = nil
+		}
+
+		if arg.IsSlice {
+			trailingSlice = arg
+		}
+	}
+
+	validateFlagNames(sig, diags)
+
+	var hasError bool
+	for _, ret := range sig.Return {
+		if ret.Type != "error" {
+			continue
+		}
+		if hasError {
+			diags.add(ret.position, "cannot have more than one error return")
+		}
+		hasError = true
+	}
+}
+
+// validateFlagNames checks that every flag that can be given to a function has a unique name.
+func validateFlagNames(sig *Signature, diags *Diagnostics) {
+	// parsecli treats flag names case-insensitively and doesn't distinguish between `-v` and `--v`
+	names := make(map[string]*Param)
+
+	for _, arg := range sig.Argument {
+		if !arg.IsFlag() {
+			if arg.Short != "" {
+				diags.add(arg.position, "argument %s has a short flag but is not a flag", arg.Name)
+			}
+			continue
+		}
+
+		name := strings.ToLower(arg.Name)
+		if existing, found := names[name]; found {
+			diags.add(arg.position, "flag --%s clashes with flag for argument %s", arg.Name, existing.Name)
+		} else {
+			names[name] = arg
+		}
+	}
+
+	for _, arg := range sig.Argument {
+		if !arg.IsFlag() || arg.Short == "" {
+			continue
+		}
+
+		short := strings.ToLower(arg.Short)
+		if existing, found := names[short]; found {
+			diags.add(arg.position, "short flag -%s for argument %s clashes with flag for argument %s", arg.Short, arg.Name, existing.Name)
+		} else {
+			names[short] = arg
+		}
+	}
+}
+
+// validateFlagConstraints checks that only flags are marked as required, given environment variables or put into flag
+// groups.
+func validateFlagConstraints(function *Function, diags *Diagnostics) {
+	for _, arg := range function.Signature.Argument {
+		if !arg.Required {
+			continue
+		}
+		if !arg.IsFlag() {
+			diags.add(arg.position, "argument %s is marked as required but is not a flag", arg.Name)
+		} else if arg.HasDefault {
+			diags.add(arg.position, "argument %s is marked as required but has a default value", arg.Name)
+		}
+	}
+
+	for _, arg := range function.Signature.Argument {
+		if arg.Env != "" && !arg.IsFlag() {
+			diags.add(arg.position, "argument %s has an environment variable but is not a flag", arg.Name)
+		}
+	}
+
+	for _, group := range append(function.ExclusiveFlags, function.AtLeastOneFlags...) {
+		for _, arg := range group {
+			if !arg.IsFlag() {
+				diags.add(arg.position, "argument %s is in a flag group but is not a flag", arg.Name)
+			}
+		}
+	}
+}
+
+// checkValue returns an error if the generated runner would not be able to convert value to the type of param.
+func checkValue(param *Param, value string) error {
+	if param.TypePackage == "time" {
+		switch param.Type {
+		case "time.Duration":
+			_, err := time.ParseDuration(value)
+			return err
+		case "time.Time":
+			layout := time.RFC3339
+			if param.Layout != "" {
+				layout = param.Layout
+			}
+			_, err := time.Parse(layout, value)
+			return err
+		}
+	}
+
+	if len(param.Choices) != 0 && !slices.Contains(param.Choices, value) {
+		return fmt.Errorf("must be one of %s", strings.Join(param.Choices, ", "))
+	}
+
+	if param.IsTextUnmarshaler {
+		// this would mean running code from the package being parsed, so is left until runtime
+		return nil
+	}
+
+	var err error
+	switch param.Kind {
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	case "int", "int8", "int16", "int32", "int64":
+		_, err = strconv.ParseInt(value, 0, kindBitSize(param.Kind))
+	case "uint", "uint8", "uint16", "uint32", "uint64", "uintptr":
+		_, err = strconv.ParseUint(value, 0, kindBitSize(param.Kind))
+	case "float32", "float64":
+		_, err = strconv.ParseFloat(value, kindBitSize(param.Kind))
+	case "complex64", "complex128":
+		_, err = strconv.ParseComplex(value, kindBitSize(param.Kind))
+	}
+	return err
+}
+
+// checkConstraints returns an error if value, which must be a valid value for param, is outside the bounds of param or
+// doesn't match its pattern.
+func checkConstraints(param *Param, value string) error {
+	if param.Min != "" && compareNumbers(param, value, param.Min) < 0 {
+		return fmt.Errorf("must be at least %s", param.Min)
+	}
+	if param.Max != "" && compareNumbers(param, value, param.Max) > 0 {
+		return fmt.Errorf("must be at most %s", param.Max)
+	}
+	if param.Pattern != "" && !regexp.MustCompile(param.Pattern).MatchString(value) {
+		return fmt.Errorf("must match %s", param.Pattern)
+	}
+	return nil
+}
+
+// isNumber returns true if param is a number that can be compared with compareNumbers.
+func isNumber(param *Param) bool {
+	if param.TypePackage == "time" {
+		return param.Type == "time.Duration"
+	}
+	if param.IsTextUnmarshaler {
+		return false
+	}
+	return param.Kind != "" && param.Kind != "bool" && param.Kind != "string" && !strings.HasPrefix(param.Kind, "complex")
+}
+
+// compareNumbers returns -1, 0 or +1 depending on whether a is less than, equal to or greater than b. Both a and b
+// must be valid values for param, which must be a number.
+func compareNumbers(param *Param, a, b string) int {
+	parse := func(value string) *big.Float {
+		x := new(big.Float)
+		switch {
+		case param.Type == "time.Duration":
+			d, _ := time.ParseDuration(value)
+			x.SetInt64(int64(d))
+		case strings.HasPrefix(param.Kind, "int"):
+			i, _ := strconv.ParseInt(value, 0, 64)
+			x.SetInt64(i)
+		case strings.HasPrefix(param.Kind, "uint"):
+			u, _ := strconv.ParseUint(value, 0, 64)
+			x.SetUint64(u)
+		default:
+			f, _ := strconv.ParseFloat(value, 64)
+			x.SetFloat64(f)
+		}
+		return x
+	}
+	return parse(a).Cmp(parse(b))
+}
+
+// kindBitSize returns the number of bits used by the basic type with the given name.
+func kindBitSize(kind string) int {
+	if size := strings.TrimLeft(kind, "abcdefghijklmnopqrstuvwxyz"); size != "" {
+		x, _ := strconv.Atoi(size)
+		return x
+	}
+	return strconv.IntSize
+}
+
+// isSupportedType returns true if the generated runner is able to convert strings into the type of param.
+func isSupportedType(param *Param) bool {
+	if param.TypePackage == "time" && (param.Type == "time.Duration" || param.Type == "time.Time") {
+		return true
+	}
+	return param.IsTextUnmarshaler || param.Kind != ""
+}
+
+// qualifier qualifies types from packages other than the one being parsed with their package name.
+func (ti *typeInfo) qualifier(pkg *types.Package) string {
+	if pkg == ti.pkg {
+		return ""
+	}
+	return pkg.Name()
+}
+
+// packagePath returns the import path of the package that typ is declared in, or an empty string if typ is not a named
+// type from another package.
+func (ti *typeInfo) packagePath(typ types.Type) string {
+	named, ok := typ.(*types.Named)
+	if !ok {
+		return ""
+	}
+	pkg := named.Obj().Pkg()
+	if pkg == nil || pkg == ti.pkg {
+		return ""
+	}
+	return pkg.Path()
+}
+
+// constantValues returns the values of the constants declared with typ in the package being parsed, in the order they
+// are declared, if typ is a string type that isn't a TextUnmarshaler.
+func (ti *typeInfo) constantValues(typ types.Type) []string {
+	named, ok := typ.(*types.Named)
+	if !ok || named.Obj().Pkg() != ti.pkg || isTextUnmarshaler(typ) {
+		return nil
+	}
+	if basic, ok := named.Underlying().(*types.Basic); !ok || basic.Info()&types.IsString == 0 {
+		return nil
+	}
+
+	var consts []*types.Const
+	scope := ti.pkg.Scope()
+	for _, name := range scope.Names() {
+		if c, ok := scope.Lookup(name).(*types.Const); ok && types.Identical(c.Type(), typ) {
+			consts = append(consts, c)
+		}
+	}
+	sort.Slice(consts, func(i, j int) bool {
+		return consts[i].Pos() < consts[j].Pos()
+	})
+
+	var values []string
+	for _, c := range consts {
+		if value := constant.StringVal(c.Val()); !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// isTextUnmarshaler returns true if a pointer to typ implements encoding.TextUnmarshaler.
+func isTextUnmarshaler(typ types.Type) bool {
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(typ), true, nil, "UnmarshalText")
+	method, ok := obj.(*types.Func)
+	if !ok {
+		return false
+	}
+
+	sig := method.Type().(*types.Signature)
+	if sig.Params().Len() != 1 || sig.Results().Len() != 1 {
+		return false
+	}
+
+	param, ok := sig.Params().At(0).Type().(*types.Slice)
+	if !ok || !types.Identical(param.Elem(), types.Typ[types.Byte]) {
+		return false
+	}
+
+	return types.Identical(sig.Results().At(0).Type(), types.Universe.Lookup("error").Type())
+}
@@ -6,8 +6,30 @@ import (
 	"strings"
 )
 
+// Slice parses input into a set of flags and a list of arguments. Every flag is assumed to be boolean unless its
+// value is given with `=`. If a flag is given more than once, the last value given is used.
 func Slice(input []string) (flags map[string]string, args []string, err error) {
+	allFlags, args, err := SliceAll(input, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	flags = make(map[string]string)
+	for key, values := range allFlags {
+		flags[key] = values[len(values)-1]
+	}
+
+	return flags, args, nil
+}
+
+// SliceAll parses input into a set of flags and a list of arguments, keeping every value given for each flag in the
+// order they were given.
+//
+// valueFlags is the set of lowercase flag names that take a value. If one of these flags is given without using `=`,
+// the next item in input is used as its value. All other flags are assumed to be boolean unless their value is given
+// with `=`.
+func SliceAll(input []string, valueFlags map[string]bool) (flags map[string][]string, args []string, err error) {
+	flags = make(map[string][]string)
 
 	var currentIndex int
 
@@ -40,17 +62,17 @@ func Slice(input []string) (flags map[string]string, args []string, err error) {
 			parsingArguments = true
 		}
 
+		// `--` on its own marks the end of the flags, so that arguments can begin with a hyphen (eg negative numbers)
+		if item == "--" && !parsingArguments {
+			parsingArguments = true
+			continue
+		}
+
 		if parsingArguments {
 
-			var y string
-			if isStringDelimiter(rune(item[0])) {
-				x, err := untilEndOfString(item, next)
-				if err != nil {
-					return nil, nil, err
-				}
-				y = x
-			} else {
-				y = item
+			y, err := stringValue(item, next)
+			if err != nil {
+				return nil, nil, err
 			}
 
 			args = append(args, y)
@@ -60,30 +82,33 @@ func Slice(input []string) (flags map[string]string, args []string, err error) {
 
 		switch hyphenPrefixLength {
 		case 2:
-			key, value, err := flag(strings.TrimPrefix(item, "--"), next)
+			key, value, err := flag(strings.TrimPrefix(item, "--"), valueFlags, next)
 			if err != nil {
 				return nil, nil, err
 			}
-			flags[strings.ToLower(key)] = value
+			flags[key] = append(flags[key], value)
 		case 1:
-			key, value, err := flag(strings.TrimPrefix(item, "-"), next)
-			if err != nil {
-				return nil, nil, err
-			}
+			item := strings.TrimPrefix(item, "-")
 
-			if len(key) > 1 {
+			if keyLength := strings.Index(item, "="); keyLength > 1 || (keyLength == -1 && len(item) > 1) {
 				// more than one key - for example `-sm=hello`
 				// this should be treated as `-s -m=hello`
-				for i := 0; i < len(key) - 1; i += 1 {
-					char := string(key[i])
-					flags[strings.ToLower(char)] = "true"
+				if keyLength == -1 {
+					keyLength = len(item)
+				}
+				for i := 0; i < keyLength-1; i += 1 {
+					char := strings.ToLower(string(item[i]))
+					flags[char] = append(flags[char], "true")
 				}
+				item = item[keyLength-1:]
+			}
 
-				flags[strings.ToLower(string(key[len(key) - 1]))] = value
-			} else {
-				// just the one key - for example `-s`
-				flags[strings.ToLower(key)] = value
+			// just the one key - for example `-s`
+			key, value, err := flag(item, valueFlags, next)
+			if err != nil {
+				return nil, nil, err
 			}
+			flags[key] = append(flags[key], value)
 		default:
 			if hyphenPrefixLength > 2 {
 				return nil, nil, errors.New("flags must have a maximum of two hyphens preceding the flag name")
@@ -97,33 +122,53 @@ func Slice(input []string) (flags map[string]string, args []string, err error) {
 	return flags, args, nil
 }
 
-func flag(in string, next func() *string) (key, value string, err error) {
+func flag(in string, valueFlags map[string]bool, next func() *string) (key, value string, err error) {
 
 	split := strings.Split(in, "=")
+	key = strings.ToLower(split[0])
 
 	switch len(split) {
 	case 1:
-		// single flag, eg `--verbose` - means `--verbose=true`
-		return split[0], "true", nil
+		if !valueFlags[key] {
+			// single flag, eg `--verbose` - means `--verbose=true`
+			return key, "true", nil
+		}
+
+		// flag that takes a value, eg `--name hello`
+		val := next()
+		if val == nil {
+			return "", "", fmt.Errorf("flag %#v requires a value", split[0])
+		}
+
+		value, err := stringValue(*val, next)
+		if err != nil {
+			return "", "", err
+		}
+
+		return key, value, nil
 	case 2:
 		// `--verbose=hello`
 
-		val := split[1]
-		if isStringDelimiter(rune(val[0])) {
-			x, err := untilEndOfString(val, next)
-			if err != nil {
-				return "", "", err
-			}
-			val = x
+		value, err := stringValue(split[1], next)
+		if err != nil {
+			return "", "", err
 		}
 
-		return split[0], val, nil
+		return key, value, nil
 	default:
 		// more than one equals sign
 		return "", "", fmt.Errorf("invalid format flag %#v", in)
 	}
 }
 
+// stringValue returns val, or the whole string literal that val begins if it starts with a string delimiter.
+func stringValue(val string, next func() *string) (string, error) {
+	if val == "" || !isStringDelimiter(rune(val[0])) {
+		return val, nil
+	}
+	return untilEndOfString(val, next)
+}
+
 func isStringDelimiter(r rune) bool {
 	return r == '"' || r == '\''
 }
@@ -26,6 +26,7 @@ func TestSlice(t *testing.T) {
 		{args: args{strings.Split(`--hello banana this is a thing "hello what oh wow ok"`, " ")}, wantFlags: map[string]string{"hello": "true"}, wantArgs: []string{"banana", "this", "is", "a", "thing", "hello what oh wow ok"}},
 		{args: args{strings.Split(`-he banana this is a thing "hello what oh wow ok"`, " ")}, wantFlags: map[string]string{"h": "true", "e": "true"}, wantArgs: []string{"banana", "this", "is", "a", "thing", "hello what oh wow ok"}},
 		{args: args{strings.Split(`-h=banana`, " ")}, wantFlags: map[string]string{"h": "banana"}},
+		{args: args{strings.Split(`-h -- -5 --x`, " ")}, wantFlags: map[string]string{"h": "true"}, wantArgs: []string{"-5", "--x"}},
 
 		{args: args{strings.Split("---hello", " ")}, wantErr: true},
 	}
@@ -46,6 +47,45 @@ func TestSlice(t *testing.T) {
 	}
 }
 
+func TestSliceAll(t *testing.T) {
+	type args struct {
+		input      []string
+		valueFlags map[string]bool
+	}
+	tests := []struct {
+		name      string
+		args      args
+		wantFlags map[string][]string
+		wantArgs  []string
+		wantErr   bool
+	}{
+		{args: args{input: strings.Split("--tag=a --tag=b", " ")}, wantFlags: map[string][]string{"tag": {"a", "b"}}},
+		{args: args{input: strings.Split("--tag a --tag b c", " "), valueFlags: map[string]bool{"tag": true}}, wantFlags: map[string][]string{"tag": {"a", "b"}}, wantArgs: []string{"c"}},
+		{args: args{input: strings.Split("--tag a", " ")}, wantFlags: map[string][]string{"tag": {"true"}}, wantArgs: []string{"a"}},
+		{args: args{input: strings.Split("--Name \"hello world\" x", " "), valueFlags: map[string]bool{"name": true}}, wantFlags: map[string][]string{"name": {"hello world"}}, wantArgs: []string{"x"}},
+		{args: args{input: strings.Split("-vt 5 -t=6", " "), valueFlags: map[string]bool{"t": true}}, wantFlags: map[string][]string{"v": {"true"}, "t": {"5", "6"}}},
+		{args: args{input: []string{"a", "", "b"}}, wantFlags: map[string][]string{}, wantArgs: []string{"a", "", "b"}},
+		{args: args{input: []string{""}}, wantFlags: map[string][]string{}, wantArgs: []string{""}},
+
+		{args: args{input: []string{"--tag"}, valueFlags: map[string]bool{"tag": true}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotFlags, gotArgs, err := SliceAll(tt.args.input, tt.args.valueFlags)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SliceAll() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(gotFlags, tt.wantFlags) {
+				t.Errorf("SliceAll() gotFlags = %v, want %v", gotFlags, tt.wantFlags)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("SliceAll() gotArgs = %#v, want %v", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
+
 func Test_countPrefixLength(t *testing.T) {
 	type args struct {
 		s      string